@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"chat/pkg/chatclient"
+	"chat/pkg/msg"
+
+	"github.com/pkg/errors"
+)
+
+// outgoing represents a file offered to another client.
+type outgoing struct {
+	path      string
+	recipient string
+}
+
+// incoming represents a file being received from another client.
+type incoming struct {
+	sender   string
+	offer    msg.Offer
+	path     string
+	file     *os.File
+	hash     hash.Hash
+	received int64
+	next     int
+}
+
+// transfers keeps track of the files sent and received by the client.
+type transfers struct {
+	c   *chatclient.Client
+	dir string // Where the files received are saved.
+
+	mu       sync.Mutex
+	outgoing map[string]outgoing
+	offers   map[string]incoming
+	incoming map[string]*incoming
+}
+
+// newTransfers registers the handlers for the file messages.
+func newTransfers(c *chatclient.Client, dir string) *transfers {
+	t := transfers{
+		c:        c,
+		dir:      dir,
+		outgoing: make(map[string]outgoing),
+		offers:   make(map[string]incoming),
+		incoming: make(map[string]*incoming),
+	}
+
+	c.On(msg.FileOffer, t.onOffer)
+	c.On(msg.FileAccept, t.onAccept)
+	c.On(msg.FileReject, t.onReject)
+	c.On(msg.FileChunk, t.onChunk)
+
+	return &t
+}
+
+// send offers the file to the recipient, it is streamed once accepted.
+func (t *transfers) send(recipient string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return errors.Wrap(err, "reading file")
+	}
+
+	o := msg.Offer{
+		ID:       msg.NewID(),
+		Size:     size,
+		Checksum: hex.EncodeToString(h.Sum(nil)),
+		Name:     filepath.Base(path),
+	}
+
+	t.mu.Lock()
+	t.outgoing[o.ID] = outgoing{path: path, recipient: recipient}
+	t.mu.Unlock()
+
+	fmt.Printf("\n*** offering %s (%d bytes) to %s ***\n", o.Name, o.Size, recipient)
+	return t.c.Send(msg.MSG{Recipient: recipient, Type: msg.FileOffer, Data: o.String()})
+}
+
+// accept saves the file offered with the id.
+func (t *transfers) accept(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	in, exists := t.offers[id]
+	if !exists {
+		return errors.Errorf("no file offered with id %s", id)
+	}
+	delete(t.offers, id)
+
+	// Never overwrite an existing file.
+	in.path = filepath.Join(t.dir, filepath.Base(in.offer.Name))
+	f, err := os.OpenFile(in.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		t.c.Send(msg.MSG{Recipient: in.sender, Type: msg.FileReject, Data: msg.FileReply(id, "unable to save the file")})
+		return err
+	}
+
+	if err := t.c.Send(msg.MSG{Recipient: in.sender, Type: msg.FileAccept, Data: msg.FileReply(id, "")}); err != nil {
+		f.Close()
+		os.Remove(in.path)
+		return err
+	}
+
+	// No chunks are sent for empty files.
+	if in.offer.Size == 0 {
+		fmt.Printf("\n*** received %s from %s, saved to %s ***\n", in.offer.Name, in.sender, in.path)
+		return f.Close()
+	}
+
+	in.file = f
+	in.hash = sha256.New()
+	t.incoming[id] = &in
+
+	return nil
+}
+
+// reject declines the file offered with the id.
+func (t *transfers) reject(id string) error {
+	t.mu.Lock()
+	in, exists := t.offers[id]
+	delete(t.offers, id)
+	t.mu.Unlock()
+
+	if !exists {
+		return errors.Errorf("no file offered with id %s", id)
+	}
+
+	return t.c.Send(msg.MSG{Recipient: in.sender, Type: msg.FileReject, Data: msg.FileReply(id, "declined")})
+}
+
+// onOffer tells about a file offered to us.
+func (t *transfers) onOffer(m msg.MSG) {
+	o, err := msg.ParseOffer(m.Data)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.offers[o.ID] = incoming{sender: m.Sender, offer: o}
+	t.mu.Unlock()
+
+	fmt.Printf("\n*** %s offers %s (%d bytes), /accept %s or /reject %s ***\n", m.Sender, o.Name, o.Size, o.ID, o.ID)
+}
+
+// onAccept streams the file once the recipient accepted it.
+func (t *transfers) onAccept(m msg.MSG) {
+	id, _ := msg.ParseFileReply(m.Data)
+
+	t.mu.Lock()
+	out, exists := t.outgoing[id]
+	if exists && out.recipient == m.Sender {
+		delete(t.outgoing, id)
+	}
+	t.mu.Unlock()
+
+	if !exists || out.recipient != m.Sender {
+		return
+	}
+
+	// Stream from another goroutine, the handlers must not block.
+	go func() {
+		if err := t.stream(id, out); err != nil {
+			fmt.Printf("\n*** sending %s to %s failed : %s ***\n", out.path, out.recipient, err)
+			return
+		}
+		fmt.Printf("\n*** sent %s to %s ***\n", out.path, out.recipient)
+	}()
+}
+
+// stream sends the file in chunks.
+func (t *transfers) stream(id string, out outgoing) error {
+	f, err := os.Open(out.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, msg.ChunkSize)
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			c := msg.Chunk{ID: id, Seq: seq, Data: buf[:n]}
+			if err := t.c.Send(msg.MSG{Recipient: out.recipient, Type: msg.FileChunk, Data: c.String()}); err != nil {
+				return err
+			}
+		}
+
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// onReject tells why a transfer was refused, by the recipient or chatd.
+func (t *transfers) onReject(m msg.MSG) {
+	id, reason := msg.ParseFileReply(m.Data)
+
+	t.mu.Lock()
+	out, sending := t.outgoing[id]
+	delete(t.outgoing, id)
+	in, receiving := t.incoming[id]
+	delete(t.incoming, id)
+	t.mu.Unlock()
+
+	switch {
+	case sending:
+		fmt.Printf("\n*** %s refused %s : %s ***\n", m.Sender, out.path, reason)
+	case receiving:
+		in.file.Close()
+		os.Remove(in.path)
+		fmt.Printf("\n*** receiving %s failed : %s ***\n", in.offer.Name, reason)
+	}
+}
+
+// onChunk writes the chunk to the file being received and checks the file
+// once complete.
+func (t *transfers) onChunk(m msg.MSG) {
+	c, err := msg.ParseChunk(m.Data)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	in, exists := t.incoming[c.ID]
+	if !exists || in.sender != m.Sender {
+		return
+	}
+
+	fail := func(reason string) {
+		delete(t.incoming, c.ID)
+		in.file.Close()
+		os.Remove(in.path)
+		fmt.Printf("\n*** receiving %s failed : %s ***\n", in.offer.Name, reason)
+	}
+
+	switch {
+	case c.Seq != in.next:
+		fail("chunk out of order")
+		return
+	case in.received+int64(len(c.Data)) > in.offer.Size:
+		fail("more data than offered")
+		return
+	}
+
+	if _, err := in.file.Write(c.Data); err != nil {
+		fail(err.Error())
+		return
+	}
+	in.hash.Write(c.Data)
+	in.received += int64(len(c.Data))
+	in.next++
+
+	if in.received < in.offer.Size {
+		return
+	}
+
+	if hex.EncodeToString(in.hash.Sum(nil)) != in.offer.Checksum {
+		fail("checksum mismatch")
+		return
+	}
+
+	delete(t.incoming, c.ID)
+	if err := in.file.Close(); err != nil {
+		fmt.Printf("\n*** receiving %s failed : %s ***\n", in.offer.Name, err)
+		return
+	}
+	fmt.Printf("\n*** received %s from %s, saved to %s ***\n", in.offer.Name, in.sender, in.path)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Keys handled while editing the line.
+const (
+	keyCtrlD     = 4
+	keyBackspace = 8
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// input reads the lines typed by the user. Once the terminal is raw the
+// line is edited here, which lets the client know what is being typed.
+type input struct {
+	r      *bufio.Reader
+	prefix string            // Prompt shown before the line.
+	raw    bool              // Keys are read as they are typed.
+	typing func(line string) // Optional, called when the line changes.
+
+	mu   sync.Mutex
+	line []rune
+}
+
+// readLine returns the next line, ending with a newline.
+func (in *input) readLine() (string, error) {
+	if !in.raw {
+		return in.r.ReadString('\n')
+	}
+
+	for {
+		r, _, err := in.r.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		in.mu.Lock()
+		switch {
+		case r == '\r', r == '\n':
+			line := string(in.line)
+			in.line = nil
+			in.mu.Unlock()
+
+			fmt.Print("\n")
+			return line + "\n", nil
+
+		case r == keyCtrlD && len(in.line) == 0:
+			in.mu.Unlock()
+			return "", io.EOF
+
+		case r == keyBackspace, r == keyDelete:
+			if len(in.line) > 0 {
+				in.line = in.line[:len(in.line)-1]
+				fmt.Print("\b \b")
+			}
+
+		case r == keyCtrlU:
+			fmt.Print(strings.Repeat("\b \b", len(in.line)))
+			in.line = nil
+
+		case r == keyEscape:
+			in.skipEscape()
+
+		case unicode.IsPrint(r):
+			in.line = append(in.line, r)
+			fmt.Print(string(r))
+		}
+		line := string(in.line)
+		in.mu.Unlock()
+
+		if in.typing != nil {
+			in.typing(line)
+		}
+	}
+}
+
+// skipEscape ignores the rest of an escape sequence, like the arrow keys
+// send.
+func (in *input) skipEscape() {
+	if b, err := in.r.ReadByte(); err != nil || b != '[' {
+		return
+	}
+
+	// The sequence ends with a byte between @ and ~.
+	for {
+		b, err := in.r.ReadByte()
+		if err != nil || (b >= '@' && b <= '~') {
+			return
+		}
+	}
+}
+
+// prompt shows the prompt on a new line, followed by what is being typed.
+func (in *input) prompt() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	fmt.Printf("\n%s%s", in.prefix, string(in.line))
+}
@@ -3,12 +3,15 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
-	"net"
 	"os"
 	"os/signal"
+	"strings"
+	"time"
 
-	"chat/internal/msg"
+	"chat/pkg/chatclient"
+	"chat/pkg/msg"
 
 	"github.com/ardanlabs/kit/cfg"
 )
@@ -21,12 +24,25 @@ CHAT_HOST=":6000" ./chat
 // Configuation settings.
 const configKey = "CHAT"
 
+// Typing notices are sent every typingEvery while writing to a room or a
+// client, and shown once every typingShown.
+const (
+	typingEvery = 3 * time.Second
+	typingShown = 10 * time.Second
+)
+
 func init() {
 
 	// Setup default values that can be overridden in the env.
 	if _, b := os.LookupEnv("CHAT_HOST"); !b {
 		os.Setenv("CHAT_HOST", ":6000")
 	}
+	if _, b := os.LookupEnv("CHAT_RECONNECT"); !b {
+		os.Setenv("CHAT_RECONNECT", "2s")
+	}
+	if _, b := os.LookupEnv("CHAT_DOWNLOADS"); !b {
+		os.Setenv("CHAT_DOWNLOADS", ".")
+	}
 
 	log.SetOutput(os.Stdout)
 	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime | log.Lmicroseconds)
@@ -46,92 +62,280 @@ func main() {
 
 	// Get configuration.
 	host := cfg.MustString("HOST")
+	reconnect := cfg.MustDuration("RECONNECT")
+	downloads := cfg.MustString("DOWNLOADS")
 
 	// =========================================================================
 	// Connect and get going.
 
-	// Let's connect back and send a TCP package
-	conn, err := net.Dial("tcp4", host)
-	if err != nil {
-		log.Println("dial", err)
-	}
-
 	// Accept keyboard input.
 	reader := bufio.NewReader(os.Stdin)
 
 	fmt.Print("\nName:> ")
 	name, _ := reader.ReadString('\n')
-	name = name[:len(name)-1]
-
-	// Show online.
-	mSend := msg.MSG{
-		Sender:    name,
-		Recipient: "",
-		Type:      msg.Init,
-		Data:      fmt.Sprintf("%s is online", name),
+	name = strings.TrimSpace(name)
+
+	// Read the keys as they are typed when the terminal allows it, so we
+	// can tell others we are typing.
+	in := input{r: reader, prefix: name + "#> "}
+	restore := func() {}
+	if r, err := makeRaw(int(os.Stdin.Fd())); err == nil {
+		in.raw = true
+		restore = r
+	}
+	defer restore()
+
+	// The client answers the server heartbeat so we are not evicted.
+	c := chatclient.New(chatclient.Config{
+		Host:      host,
+		Name:      name,
+		Reconnect: reconnect,
+	})
+
+	// Print the presence and room notices.
+	notice := func(m msg.MSG) {
+		switch m.Type {
+		case msg.Join:
+			fmt.Printf("\n*** %s is online ***\n", m.Sender)
+		case msg.Leave:
+			fmt.Printf("\n*** %s is offline ***\n", m.Sender)
+		case msg.JoinRoom:
+			fmt.Printf("\n*** %s joined %s ***\n", m.Sender, m.Recipient)
+		case msg.LeaveRoom:
+			fmt.Printf("\n*** %s left %s ***\n", m.Sender, m.Recipient)
+		}
+		in.prompt()
 	}
-	data := msg.Encode(mSend)
-	if _, err := conn.Write(data); err != nil {
-		log.Println("write", err)
+	for _, typ := range []uint8{msg.Join, msg.Leave, msg.JoinRoom, msg.LeaveRoom} {
+		c.On(typ, notice)
 	}
 
-	// Receiving goroutine.
-	go func() {
-		for {
-			data, _, err := msg.Read(conn)
-			if err != nil {
+	// Print the messages with the one they reply to and their reactions.
+	// The sender of a direct message is told once it is shown.
+	msgs := newMessages(name)
+	c.OnMessage(func(m msg.MSG) {
+		msgs.add(m)
+		fmt.Printf("\n%s\n", msgs.render(m))
+		in.prompt()
+
+		if m.Recipient == name && m.ID != "" {
+			if err := c.MarkRead(m.ID); err != nil {
 				log.Println("read", err)
-				return
 			}
+		}
+	})
 
-			mRecv := msg.Decode(data)
+	// Print the latest version of the messages changed.
+	change := func(m msg.MSG) {
+		msgs.update(m)
 
-			if mRecv.Type == msg.InCache {
-				fmt.Printf("\nUsername '%s' is currently connected.\n", mRecv.Sender)
-				fmt.Println("Please try a different username on next run.")
-				os.Exit(1)
-			}
+		what := "edited"
+		switch m.Type {
+		case msg.Delete:
+			what = "deleted"
+		case msg.React:
+			r, _ := msg.ParseReaction(m.Data)
+			what = "reacted " + r.Emoji + " to"
+		case msg.ReadReceipt:
+			what = "read"
+		}
 
-			log.Println(mRecv)
-			fmt.Printf("\n%s#> ", name)
+		if orig, exists := msgs.get(m.ID); exists {
+			fmt.Printf("\n*** %s %s ***\n%s\n", m.Sender, what, msgs.render(orig))
+		} else {
+			fmt.Printf("\n*** %s %s [%s] ***\n", m.Sender, what, m.ID)
+		}
+		in.prompt()
+	}
+	for _, typ := range []uint8{msg.Edit, msg.Delete, msg.React, msg.ReadReceipt} {
+		c.On(typ, change)
+	}
+
+	// Print the messages found by our searches, they can be replied and
+	// reacted to.
+	c.On(msg.Search, func(m msg.MSG) {
+		if m.ID == "" {
+			fmt.Printf("\n*** %s messages found ***\n", m.Data)
+			in.prompt()
+			return
 		}
-	}()
 
-	// Process keyboard input.
+		if _, exists := msgs.get(m.ID); !exists {
+			msgs.add(m)
+		}
+		fmt.Printf("\n%s\n", msgs.render(m))
+	})
+
+	// Print who is typing to us, once in a while.
+	shown := make(map[string]time.Time)
+	c.On(msg.Typing, func(m msg.MSG) {
+		key := m.Sender + "/" + m.Recipient
+		if time.Since(shown[key]) < typingShown {
+			return
+		}
+		shown[key] = time.Now()
+
+		where := ""
+		if msg.IsRoom(m.Recipient) {
+			where = " in " + m.Recipient
+		}
+		fmt.Printf("\n*** %s is typing%s ***\n", m.Sender, where)
+		in.prompt()
+	})
+
+	// Tell the room or the client we are writing to that we are typing.
+	var typingTo string
+	var typingAt time.Time
+	in.typing = func(line string) {
+		recipient := msg.GetRecipient(line)
+		if recipient == "" || msg.GetData(line) == "" {
+			return
+		}
+		if recipient == typingTo && time.Since(typingAt) < typingEvery {
+			return
+		}
+
+		typingTo, typingAt = recipient, time.Now()
+		if err := c.Typing(recipient); err != nil {
+			log.Println("typing", err)
+		}
+	}
+
+	// Files are offered with /send and saved once accepted.
+	files := newTransfers(c, downloads)
+
+	// Register with the server, which announces us to everyone else.
+	if err := c.Connect(); err != nil {
+		log.Println("connect", err)
+		os.Exit(1)
+	}
+
+	// Process keyboard input until it ends, with Ctrl-D.
+	quit := make(chan struct{})
 	go func() {
+		defer close(quit)
+
 		for {
-			fmt.Printf("\n%s#> ", name)
-			message, _ := reader.ReadString('\n')
+			in.prompt()
+			message, err := in.readLine()
+			if err != nil {
+				if err != io.EOF {
+					log.Println("read", err)
+				}
+				return
+			}
 
 			mSend := msg.MSG{
-				Sender:    name,
+				ID:        msg.NewID(),
 				Recipient: msg.GetRecipient(message),
 				Type:      msg.Message,
 				Data:      msg.GetData(message),
 			}
 
-			data := msg.Encode(mSend)
+			// Room commands.
+			if room, ok := strings.CutPrefix(message, "/join "); ok {
+				mSend = msg.MSG{Recipient: strings.TrimSpace(room), Type: msg.JoinRoom}
+			}
+			if room, ok := strings.CutPrefix(message, "/part "); ok {
+				mSend = msg.MSG{Recipient: strings.TrimSpace(room), Type: msg.LeaveRoom}
+			}
 
-			if _, err := conn.Write(data); err != nil {
+			// Message commands, they refer to the id shown with the messages.
+			if args, ok := strings.CutPrefix(message, "/reply "); ok {
+				id, data, _ := strings.Cut(strings.TrimSpace(args), " ")
+				reply, exists := msgs.reply(id, strings.TrimSpace(data))
+				if !exists {
+					log.Println("reply", "unknown message", id)
+					continue
+				}
+				mSend = reply
+			}
+			if args, ok := strings.CutPrefix(message, "/react "); ok {
+				id, emoji, _ := strings.Cut(strings.TrimSpace(args), " ")
+				if err := c.React(id, strings.TrimSpace(emoji)); err != nil {
+					log.Println("react", err)
+				}
+				continue
+			}
+			if args, ok := strings.CutPrefix(message, "/edit "); ok {
+				id, data, _ := strings.Cut(strings.TrimSpace(args), " ")
+				mSend = msg.MSG{ID: id, Type: msg.Edit, Data: strings.TrimSpace(data)}
+			}
+			if id, ok := strings.CutPrefix(message, "/delete "); ok {
+				mSend = msg.MSG{ID: strings.TrimSpace(id), Type: msg.Delete}
+			}
+
+			// Search the history, of a single room or client when it comes
+			// first.
+			if query, ok := strings.CutPrefix(message, "/search "); ok {
+				where := msg.GetRecipient(query)
+				if where != "" {
+					query = msg.GetData(query)
+				}
+				if err := c.Search(where, strings.TrimSpace(query)); err != nil {
+					log.Println("search", err)
+				}
+				continue
+			}
+
+			// File commands.
+			if args, ok := strings.CutPrefix(message, "/send "); ok {
+				recipient, path, _ := strings.Cut(strings.TrimSpace(args), " ")
+				if err := files.send(strings.TrimPrefix(recipient, "@"), strings.TrimSpace(path)); err != nil {
+					log.Println("send", err)
+				}
+				continue
+			}
+			if id, ok := strings.CutPrefix(message, "/accept "); ok {
+				if err := files.accept(strings.TrimSpace(id)); err != nil {
+					log.Println("accept", err)
+				}
+				continue
+			}
+			if id, ok := strings.CutPrefix(message, "/reject "); ok {
+				if err := files.reject(strings.TrimSpace(id)); err != nil {
+					log.Println("reject", err)
+				}
+				continue
+			}
+
+			if err := c.Send(mSend); err != nil {
 				log.Println("write", err)
+				continue
+			}
+
+			// Our own messages and changes are not sent back to us.
+			switch mSend.Type {
+			case msg.Message:
+				mSend.Sender = name
+				msgs.add(mSend)
+				fmt.Printf("\n%s\n", msgs.render(mSend))
+			case msg.Edit, msg.Delete:
+				msgs.update(mSend)
 			}
 		}
 	}()
 
-	// Listen for an interrupt signal from the OS.
+	// Listen for an interrupt signal from the OS, or the end of the input.
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
-	<-sigChan
-
-	// Show offline.
-	mSend = msg.MSG{
-		Sender:    name,
-		Recipient: "",
-		Type:      msg.Message,
-		Data:      fmt.Sprintf("%s is offline", name),
+
+	select {
+	case <-sigChan:
+	case <-quit:
+	case <-c.Done():
+		restore()
+		if c.Err() == chatclient.ErrNameTaken {
+			fmt.Printf("\nUsername '%s' is currently connected.\n", name)
+			fmt.Println("Please try a different username on next run.")
+		} else {
+			log.Println("read", c.Err())
+		}
+		os.Exit(1)
 	}
-	data = msg.Encode(mSend)
-	if _, err := conn.Write(data); err != nil {
-		log.Println("write", err)
+
+	// Closing the connection has the server announce us offline.
+	if err := c.Close(); err != nil {
+		log.Println("close", err)
 	}
 }
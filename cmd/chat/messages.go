@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"chat/pkg/msg"
+)
+
+// Markers shown after the direct messages sent, once sent and once read.
+const (
+	markSent = "\u2713"
+	markRead = "\u2713\u2713"
+)
+
+// maxSeen is the number of messages kept to show the context of the
+// replies and reactions.
+const maxSeen = 1000
+
+// seen represents a message shown to the user.
+type seen struct {
+	m         msg.MSG
+	edited    bool
+	deleted   bool
+	read      bool
+	reactions map[string]int
+}
+
+// messages keeps the latest messages sent and received.
+type messages struct {
+	name string // Name of the user, to tell the direct messages apart.
+
+	mu    sync.Mutex
+	msgs  map[string]*seen
+	order []string
+}
+
+// newMessages returns an empty set of messages for the user.
+func newMessages(name string) *messages {
+	return &messages{
+		name: name,
+		msgs: make(map[string]*seen),
+	}
+}
+
+// add keeps the message, forgetting the oldest one once full.
+func (ms *messages) add(m msg.MSG) {
+	if m.ID == "" {
+		return
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if len(ms.order) == maxSeen {
+		delete(ms.msgs, ms.order[0])
+		ms.order = ms.order[1:]
+	}
+
+	ms.msgs[m.ID] = &seen{m: m}
+	ms.order = append(ms.order, m.ID)
+}
+
+// get returns the message with the id.
+func (ms *messages) get(id string) (msg.MSG, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	s, exists := ms.msgs[id]
+	if !exists {
+		return msg.MSG{}, false
+	}
+	return s.m, true
+}
+
+// reply returns a reply to the message with the id, sent where the message
+// was.
+func (ms *messages) reply(id string, data string) (msg.MSG, bool) {
+	m, exists := ms.get(id)
+	if !exists {
+		return msg.MSG{}, false
+	}
+
+	recipient := m.Recipient
+	if recipient != "" && !msg.IsRoom(recipient) && m.Sender != ms.name {
+		recipient = m.Sender
+	}
+
+	reply := msg.MSG{
+		ID:        msg.NewID(),
+		ReplyTo:   id,
+		Recipient: recipient,
+		Type:      msg.Message,
+		Data:      data,
+	}
+
+	return reply, true
+}
+
+// update applies the edit, delete, reaction or read receipt to the message
+// it refers to.
+func (ms *messages) update(m msg.MSG) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	s, exists := ms.msgs[m.ID]
+	if !exists {
+		return
+	}
+
+	switch m.Type {
+	case msg.Edit:
+		s.m.Data = m.Data
+		s.edited = true
+	case msg.Delete:
+		s.m.Data = ""
+		s.deleted = true
+	case msg.React:
+		r, err := msg.ParseReaction(m.Data)
+		if err != nil {
+			return
+		}
+		if s.reactions == nil {
+			s.reactions = make(map[string]int)
+		}
+		s.reactions[r.Emoji] = r.Count
+		if r.Count == 0 {
+			delete(s.reactions, r.Emoji)
+		}
+	case msg.ReadReceipt:
+		s.read = true
+	}
+}
+
+// render returns the message as shown to the user, with the message it
+// replies to and its reactions.
+func (ms *messages) render(m msg.MSG) string {
+	var b strings.Builder
+
+	if m.ReplyTo != "" {
+		b.WriteString("  > ")
+		if orig, exists := ms.get(m.ReplyTo); exists {
+			b.WriteString(ms.line(orig))
+		} else {
+			b.WriteString("[" + m.ReplyTo + "]")
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString(ms.line(m))
+	return b.String()
+}
+
+// line returns a single line for the message: its id, who sent it where,
+// the data, whether a direct message sent was read and the reactions.
+func (ms *messages) line(m msg.MSG) string {
+	to, mark := "", ""
+	switch {
+	case msg.IsRoom(m.Recipient):
+		to = " " + m.Recipient
+	case m.Recipient != "" && m.Sender == ms.name:
+		to = " -> " + m.Recipient
+		mark = " " + markSent
+	case m.Recipient != "":
+		to = " (direct)"
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	data := m.Data
+	var reactions []string
+	if s, exists := ms.msgs[m.ID]; exists {
+		data = s.m.Data
+		switch {
+		case s.deleted:
+			data = "(deleted)"
+		case s.edited:
+			data += " (edited)"
+		}
+		if mark != "" && s.read {
+			mark = " " + markRead
+		}
+		for emoji, count := range s.reactions {
+			reactions = append(reactions, fmt.Sprintf("%s %d", emoji, count))
+		}
+	}
+
+	line := fmt.Sprintf("[%s] %s%s: %s%s", m.ID, m.Sender, to, data, mark)
+	if len(reactions) > 0 {
+		sort.Strings(reactions)
+		line += "  [" + strings.Join(reactions, ", ") + "]"
+	}
+
+	return line
+}
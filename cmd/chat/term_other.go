@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "github.com/pkg/errors"
+
+// makeRaw is not supported on this platform, lines are read once complete.
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal not supported")
+}
@@ -0,0 +1,29 @@
+//go:build linux || darwin
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// makeRaw stops the terminal from buffering and echoing the lines so the
+// keys are read as they are typed. It returns the function restoring the
+// terminal. Signals like Ctrl-C still work.
+func makeRaw(fd int) (func(), error) {
+	old, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := *old
+	raw.Lflag &^= unix.ICANON | unix.ECHO
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, &raw); err != nil {
+		return nil, err
+	}
+
+	restore := func() {
+		unix.IoctlSetTermios(fd, ioctlSetTermios, old)
+	}
+	return restore, nil
+}
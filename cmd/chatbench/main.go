@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chat/pkg/msg"
+
+	"github.com/ardanlabs/kit/cfg"
+)
+
+/*
+Start the Benchmark against a running chatd:
+BENCH_HOST=":6000" BENCH_CLIENTS=50 BENCH_RATE=500 BENCH_DURATION=10s BENCH_DM_PERCENT=20 ./chatbench
+*/
+
+// Configuation settings.
+const configKey = "BENCH"
+
+// prefix marks the messages sent by the benchmark. The data carries the
+// time the message was sent to measure the fan-out latency.
+const prefix = "bench "
+
+func init() {
+
+	// Setup default values that can be overridden in the env.
+	if _, b := os.LookupEnv("BENCH_HOST"); !b {
+		os.Setenv("BENCH_HOST", ":6000")
+	}
+	if _, b := os.LookupEnv("BENCH_CLIENTS"); !b {
+		os.Setenv("BENCH_CLIENTS", "10")
+	}
+	if _, b := os.LookupEnv("BENCH_RATE"); !b {
+		os.Setenv("BENCH_RATE", "100")
+	}
+	if _, b := os.LookupEnv("BENCH_DURATION"); !b {
+		os.Setenv("BENCH_DURATION", "10s")
+	}
+	if _, b := os.LookupEnv("BENCH_DM_PERCENT"); !b {
+		os.Setenv("BENCH_DM_PERCENT", "20")
+	}
+
+	log.SetOutput(os.Stdout)
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime | log.Lmicroseconds)
+}
+
+// stats collects the results of the benchmark.
+type stats struct {
+	sent      int64
+	delivered int64
+	errors    int64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+// record saves the fan-out latency of a delivered message.
+func (s *stats) record(d time.Duration) {
+	atomic.AddInt64(&s.delivered, 1)
+
+	s.mu.Lock()
+	s.latencies = append(s.latencies, d)
+	s.mu.Unlock()
+}
+
+// percentile returns the latency for the specified percentile of the sorted
+// latencies.
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	i := int(float64(len(latencies))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(latencies) {
+		i = len(latencies) - 1
+	}
+	return latencies[i]
+}
+
+// client represents a simulated chat client.
+type client struct {
+	name string
+	conn net.Conn
+	mu   sync.Mutex
+}
+
+// send writes the message to the server.
+func (c *client) send(m msg.MSG) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.conn.Write(msg.Encode(m))
+	return err
+}
+
+// receive reads messages until the connection is closed, recording the
+// latency of the benchmark messages.
+func (c *client) receive(s *stats) {
+	for {
+		data, _, err := msg.Read(c.conn)
+		if err != nil {
+			return
+		}
+
+		m, err := msg.Decode(data)
+		if err != nil {
+			atomic.AddInt64(&s.errors, 1)
+			return
+		}
+
+		switch m.Type {
+		case msg.Ping:
+			if err := c.send(msg.MSG{Sender: c.name, Type: msg.Pong}); err != nil {
+				atomic.AddInt64(&s.errors, 1)
+			}
+
+		case msg.Message:
+			sent, err := strconv.ParseInt(strings.TrimPrefix(m.Data, prefix), 10, 64)
+			if err != nil {
+				continue
+			}
+			s.record(time.Since(time.Unix(0, sent)))
+
+		case msg.InCache:
+			log.Printf("client : %s : name already connected", c.name)
+			atomic.AddInt64(&s.errors, 1)
+		}
+	}
+}
+
+func main() {
+
+	// =========================================================================
+	// Init the configuration system.
+
+	if err := cfg.Init(cfg.EnvProvider{Namespace: configKey}); err != nil {
+		log.Println("Error initalizing configuration system", err)
+		os.Exit(1)
+	}
+
+	log.Println("Configuration\n", cfg.Log())
+
+	// Get configuration.
+	host := cfg.MustString("HOST")
+	clients := cfg.MustInt("CLIENTS")
+	rate := cfg.MustInt("RATE")
+	duration := cfg.MustDuration("DURATION")
+	dmPercent := cfg.MustInt("DM_PERCENT")
+
+	if clients < 2 || rate < 1 {
+		log.Println("main : need at least 2 clients and a rate of 1 message per second")
+		os.Exit(1)
+	}
+
+	// Messages are sent on a ticker, which needs at least a nanosecond
+	// between them.
+	if rate > int(time.Second) {
+		log.Printf("main : the rate can't be over %d messages per second", int(time.Second))
+		os.Exit(1)
+	}
+
+	// =========================================================================
+	// Connect the clients.
+
+	var s stats
+	var wg sync.WaitGroup
+
+	clts := make([]*client, 0, clients)
+	for i := 0; i < clients; i++ {
+		name := fmt.Sprintf("bench%d", i)
+
+		conn, err := net.Dial("tcp4", host)
+		if err != nil {
+			log.Printf("main : dial : %s : %s", name, err)
+			os.Exit(1)
+		}
+
+		c := client{name: name, conn: conn}
+		if err := c.send(msg.MSG{Sender: name, Type: msg.Init}); err != nil {
+			log.Printf("main : init : %s : %s", name, err)
+			os.Exit(1)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.receive(&s)
+		}()
+
+		clts = append(clts, &c)
+	}
+
+	// Give the server a moment to register everyone.
+	time.Sleep(time.Second)
+	log.Printf("main : %d clients connected, sending %d msg/s for %v", clients, rate, duration)
+
+	// =========================================================================
+	// Send messages at the configured rate.
+
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	deadline := time.After(duration)
+	start := time.Now()
+
+send:
+	for {
+		select {
+		case <-ticker.C:
+			sender := clts[rand.Intn(len(clts))]
+
+			m := msg.MSG{
+				Sender: sender.name,
+				Type:   msg.Message,
+				Data:   prefix + strconv.FormatInt(time.Now().UnixNano(), 10),
+			}
+
+			// Pick someone else as the recipient for direct messages.
+			if rand.Intn(100) < dmPercent {
+				recipient := clts[rand.Intn(len(clts))]
+				for recipient == sender {
+					recipient = clts[rand.Intn(len(clts))]
+				}
+				m.Recipient = recipient.name
+			}
+
+			if err := sender.send(m); err != nil {
+				atomic.AddInt64(&s.errors, 1)
+				continue
+			}
+			atomic.AddInt64(&s.sent, 1)
+
+		case <-deadline:
+			break send
+		}
+	}
+	ticker.Stop()
+	elapsed := time.Since(start)
+
+	// Wait for the messages in flight before disconnecting.
+	time.Sleep(time.Second)
+	for _, c := range clts {
+		c.conn.Close()
+	}
+	wg.Wait()
+
+	// =========================================================================
+	// Report.
+
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\nClients:     %d\n", clients)
+	fmt.Fprintf(&b, "Duration:    %v\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&b, "Sent:        %d (%.1f msg/s)\n", s.sent, float64(s.sent)/elapsed.Seconds())
+	fmt.Fprintf(&b, "Delivered:   %d (%.1f msg/s)\n", s.delivered, float64(s.delivered)/elapsed.Seconds())
+	fmt.Fprintf(&b, "Errors:      %d\n", s.errors)
+	fmt.Fprintf(&b, "Latency p50: %v\n", percentile(s.latencies, 50))
+	fmt.Fprintf(&b, "Latency p90: %v\n", percentile(s.latencies, 90))
+	fmt.Fprintf(&b, "Latency p99: %v\n", percentile(s.latencies, 99))
+	if n := len(s.latencies); n > 0 {
+		fmt.Fprintf(&b, "Latency max: %v\n", s.latencies[n-1])
+	}
+
+	log.Println(b.String())
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"chat/internal/platform/history"
+	"chat/pkg/msg"
+
+	"github.com/pkg/errors"
+)
+
+// Formats of the exported messages.
+const (
+	formatJSONL = "jsonl"
+	formatText  = "text"
+)
+
+// runHistory runs the export and import subcommands on the history file at
+// path.
+func runHistory(path string, cmd string, args []string) error {
+	if path == "" {
+		return errors.New("CHAT_HISTORY must name the history file")
+	}
+
+	switch cmd {
+	case "export":
+		return export(path, args)
+	case "import":
+		return load(path, args)
+	}
+
+	return errors.Errorf("unknown command %q", cmd)
+}
+
+// export writes the messages of the history to a file or the standard
+// output. The history file is only read so it can be exported while chatd is
+// running.
+func export(path string, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	room := fs.String("room", "", "export only the messages sent to the room")
+	since := fs.String("since", "", "export the messages sent from this time, RFC3339 or a date")
+	until := fs.String("until", "", "export the messages sent before this time, RFC3339 or a date")
+	format := fs.String("format", formatJSONL, "format of the export, jsonl or text")
+	out := fs.String("o", "-", "file to write to, - for the standard output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *room != "" && !msg.IsRoom(*room) {
+		return errors.Errorf("invalid room %q", *room)
+	}
+	from, err := parseTime(*since)
+	if err != nil {
+		return errors.Wrap(err, "since")
+	}
+	to, err := parseTime(*until)
+	if err != nil {
+		return errors.Wrap(err, "until")
+	}
+
+	write := history.WriteJSONL
+	switch *format {
+	case formatJSONL:
+	case formatText:
+		write = history.WriteText
+	default:
+		return errors.Errorf("unknown format %q", *format)
+	}
+
+	s, err := history.Load(path)
+	if err != nil {
+		return err
+	}
+	msgs := s.Range(*room, from, to)
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return errors.Wrap(err, "creating export")
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := write(w, msgs); err != nil {
+		return errors.Wrap(err, "writing export")
+	}
+
+	// Transcripts leave the deleted messages out.
+	n := len(msgs)
+	if *format == formatText {
+		for _, m := range msgs {
+			if m.Deleted != nil {
+				n--
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d messages\n", n)
+	return nil
+}
+
+// load adds the messages of the exported files, or of the standard input, to
+// the history. Messages with an id already in the history are skipped.
+// chatd must not be running, it would not see them and opening the history
+// rewrites the file, so the import is refused while the history is open.
+func load(path string, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", formatJSONL, "format of the files, jsonl or text")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	read := history.ReadJSONL
+	switch *format {
+	case formatJSONL:
+	case formatText:
+		read = history.ReadText
+	default:
+		return errors.Errorf("unknown format %q", *format)
+	}
+
+	var msgs []history.Message
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, name := range files {
+		var r io.Reader = os.Stdin
+		if name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				return errors.Wrap(err, "opening import")
+			}
+			defer f.Close()
+			r = f
+		}
+
+		m, err := read(r)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", name)
+		}
+		msgs = append(msgs, m...)
+	}
+
+	// The ids must be usable in the messages sent to the clients.
+	for _, m := range msgs {
+		if m.ID == "" || strings.Contains(m.ID, " ") || len(m.ID) > msg.MaxIDLength {
+			return errors.Errorf("invalid id %q", m.ID)
+		}
+	}
+
+	s, err := history.Open(path)
+	if err == history.ErrLocked {
+		return errors.New("chatd is running with the history, stop it before importing")
+	}
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	var added, skipped int
+	for _, m := range msgs {
+		switch err := s.Add(m); err {
+		case nil:
+			added++
+		case history.ErrExists:
+			skipped++
+		default:
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "imported %d messages, skipped %d already in the history\n", added, skipped)
+	return nil
+}
+
+// parseTime parses a time given as RFC3339 or as a date in UTC. An empty
+// string is the zero time.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
@@ -1,15 +1,26 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"time"
 
 	"chat/cmd/chatd/process"
+	"chat/cmd/chatd/web"
+	"chat/internal/platform/bus"
 	"chat/internal/platform/cache"
+	"chat/internal/platform/history"
+	"chat/internal/platform/webhook"
+	"chat/pkg/msg"
 
 	"github.com/ardanlabs/kit/cfg"
 	"github.com/ardanlabs/kit/tcp"
+	natsgo "github.com/nats-io/nats.go"
 )
 
 /*
@@ -18,6 +29,48 @@ gnatsd
 
 Start the Service:
 CHAT_HOST=":6000" ./chatd
+
+Keep sessions in a NATS key-value bucket (requires JetStream, nats-server -js):
+CHAT_SESSION_STORE="kv" ./chatd
+
+Run NATS inside chatd instead of a separate gnatsd:
+CHAT_NATS_EMBEDDED=true ./chatd
+
+Accept newline delimited JSON clients too:
+CHAT_JSON_HOST=":6001" ./chatd
+
+Accept WebSocket clients on ws://host:6080/ws and serve the web client
+on http://host:6080:
+CHAT_WS_HOST=":6080" ./chatd
+
+Accept IRC clients (weechat, irssi, ...):
+CHAT_IRC_HOST=":6667" ./chatd
+
+Post the messages to the webhooks listed in a JSON file:
+CHAT_WEBHOOKS="webhooks.json" ./chatd
+
+Run only some of the builtin bots, or none with an empty list:
+CHAT_BOTS="echo,roll" ./chatd
+
+Limit file transfers to 1MB files and 50MB a day per user:
+CHAT_FILE_MAX_SIZE=1048576 CHAT_FILE_QUOTA=52428800 ./chatd
+
+Keep the message history in a file, and let admin edit and delete any message:
+CHAT_HISTORY="history.jsonl" CHAT_OPERATORS="admin" ./chatd
+
+Export the history of a room for January as a transcript, or as JSON lines
+by default, and load it back while chatd is stopped:
+CHAT_HISTORY="history.jsonl" ./chatd export -room "#go" -since 2024-01-01 -until 2024-02-01 -format text -o go.txt
+CHAT_HISTORY="history.jsonl" ./chatd import -format text go.txt
+
+Let scripts post into rooms over HTTP:
+CHAT_API_HOST=":6081" CHAT_API_TOKEN="s3cr3t" ./chatd
+
+Run a standalone node without NATS:
+CHAT_BUS="memory" ./chatd
+
+Shard room messages over NATS subject partitions:
+CHAT_NATS_PARTITIONS=16 ./chatd
 /*
 
 Things TODO:
@@ -37,9 +90,81 @@ func init() {
 	if _, b := os.LookupEnv("CHAT_HOST"); !b {
 		os.Setenv("CHAT_HOST", ":6000")
 	}
+	if _, b := os.LookupEnv("CHAT_JSON_HOST"); !b {
+		os.Setenv("CHAT_JSON_HOST", "")
+	}
+	if _, b := os.LookupEnv("CHAT_WS_HOST"); !b {
+		os.Setenv("CHAT_WS_HOST", "")
+	}
+	if _, b := os.LookupEnv("CHAT_IRC_HOST"); !b {
+		os.Setenv("CHAT_IRC_HOST", "")
+	}
 	if _, b := os.LookupEnv("CHAT_NATS_HOST"); !b {
 		os.Setenv("CHAT_NATS_HOST", "nats://localhost:4222")
 	}
+	if _, b := os.LookupEnv("CHAT_BUS"); !b {
+		os.Setenv("CHAT_BUS", "nats")
+	}
+	if _, b := os.LookupEnv("CHAT_NATS_EMBEDDED"); !b {
+		os.Setenv("CHAT_NATS_EMBEDDED", "false")
+	}
+	if _, b := os.LookupEnv("CHAT_NATS_STORE_DIR"); !b {
+		os.Setenv("CHAT_NATS_STORE_DIR", "")
+	}
+	if _, b := os.LookupEnv("CHAT_NATS_PARTITIONS"); !b {
+		os.Setenv("CHAT_NATS_PARTITIONS", "0")
+	}
+	if _, b := os.LookupEnv("CHAT_HEARTBEAT"); !b {
+		os.Setenv("CHAT_HEARTBEAT", "30s")
+	}
+	if _, b := os.LookupEnv("CHAT_HEARTBEAT_MISSED"); !b {
+		os.Setenv("CHAT_HEARTBEAT_MISSED", "3")
+	}
+	if _, b := os.LookupEnv("CHAT_BOTS"); !b {
+		os.Setenv("CHAT_BOTS", "echo,time,roll")
+	}
+	if _, b := os.LookupEnv("CHAT_API_HOST"); !b {
+		os.Setenv("CHAT_API_HOST", "")
+	}
+	if _, b := os.LookupEnv("CHAT_API_TOKEN"); !b {
+		os.Setenv("CHAT_API_TOKEN", "")
+	}
+	if _, b := os.LookupEnv("CHAT_API_SENDER"); !b {
+		os.Setenv("CHAT_API_SENDER", "bot")
+	}
+	if _, b := os.LookupEnv("CHAT_WEBHOOKS"); !b {
+		os.Setenv("CHAT_WEBHOOKS", "")
+	}
+	if _, b := os.LookupEnv("CHAT_WEBHOOK_RETRIES"); !b {
+		os.Setenv("CHAT_WEBHOOK_RETRIES", "3")
+	}
+	if _, b := os.LookupEnv("CHAT_WEBHOOK_BACKOFF"); !b {
+		os.Setenv("CHAT_WEBHOOK_BACKOFF", "1s")
+	}
+	if _, b := os.LookupEnv("CHAT_FILE_MAX_SIZE"); !b {
+		os.Setenv("CHAT_FILE_MAX_SIZE", "10485760")
+	}
+	if _, b := os.LookupEnv("CHAT_FILE_QUOTA"); !b {
+		os.Setenv("CHAT_FILE_QUOTA", "104857600")
+	}
+	if _, b := os.LookupEnv("CHAT_FILE_QUOTA_WINDOW"); !b {
+		os.Setenv("CHAT_FILE_QUOTA_WINDOW", "24h")
+	}
+	if _, b := os.LookupEnv("CHAT_HISTORY"); !b {
+		os.Setenv("CHAT_HISTORY", "")
+	}
+	if _, b := os.LookupEnv("CHAT_OPERATORS"); !b {
+		os.Setenv("CHAT_OPERATORS", "")
+	}
+	if _, b := os.LookupEnv("CHAT_SESSION_STORE"); !b {
+		os.Setenv("CHAT_SESSION_STORE", "memory")
+	}
+	if _, b := os.LookupEnv("CHAT_SESSION_BUCKET"); !b {
+		os.Setenv("CHAT_SESSION_BUCKET", "chat_sessions")
+	}
+	if _, b := os.LookupEnv("CHAT_SESSION_TTL"); !b {
+		os.Setenv("CHAT_SESSION_TTL", "30s")
+	}
 
 	log.SetOutput(os.Stdout)
 	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime | log.Lmicroseconds)
@@ -55,26 +180,114 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Export or import the history instead of running the service. The
+	// export can be written to the standard output, so the logs are not.
+	if len(os.Args) > 1 {
+		log.SetOutput(os.Stderr)
+		if err := runHistory(cfg.MustString("HISTORY"), os.Args[1], os.Args[2:]); err != nil {
+			log.Printf("main : %s : %s", os.Args[1], err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	log.Println("Configuration\n", cfg.Log())
 
 	// Get configuration.
 	host := cfg.MustString("HOST")
+	jsonHost := cfg.MustString("JSON_HOST")
+	wsHost := cfg.MustString("WS_HOST")
+	ircHost := cfg.MustString("IRC_HOST")
+	botNames := cfg.MustString("BOTS")
+	apiHost := cfg.MustString("API_HOST")
+	apiToken := cfg.MustString("API_TOKEN")
+	apiSender := cfg.MustString("API_SENDER")
+	busType := cfg.MustString("BUS")
 	nats := cfg.MustString("NATS_HOST")
+	embedded := cfg.MustBool("NATS_EMBEDDED")
+	storeDir := cfg.MustString("NATS_STORE_DIR")
+	partitions := cfg.MustInt("NATS_PARTITIONS")
+	store := cfg.MustString("SESSION_STORE")
+	bucket := cfg.MustString("SESSION_BUCKET")
+	sessionTTL := cfg.MustDuration("SESSION_TTL")
+	heartbeat := cfg.MustDuration("HEARTBEAT")
+	missed := cfg.MustInt("HEARTBEAT_MISSED")
+	webhooks := cfg.MustString("WEBHOOKS")
+	webhookRetries := cfg.MustInt("WEBHOOK_RETRIES")
+	webhookBackoff := cfg.MustDuration("WEBHOOK_BACKOFF")
+	fileMaxSize := cfg.MustInt("FILE_MAX_SIZE")
+	fileQuota := cfg.MustInt("FILE_QUOTA")
+	fileWindow := cfg.MustDuration("FILE_QUOTA_WINDOW")
+	historyPath := cfg.MustString("HISTORY")
+	operators := cfg.MustString("OPERATORS")
+
+	if busType == "memory" && store == "kv" {
+		log.Println("main : the kv session store requires the nats bus")
+		return
+	}
+
+	if apiHost != "" && apiToken == "" {
+		log.Println("main : the HTTP API requires a token")
+		return
+	}
+
+	// =========================================================================
+	// Init the embedded NATS server.
+
+	if embedded {
+		ns, err := process.StartEmbeddedNATS(nats, storeDir)
+		if err != nil {
+			log.Printf("main : %s", err)
+			return
+		}
+		defer ns.Shutdown()
+
+		// Everything connects to the embedded server from now on.
+		nats = ns.ClientURL()
+	}
 
 	// =========================================================================
 	// Init the caching system.
 
-	cc := cache.New()
+	var cc cache.SessionStore
+	switch store {
+	case "memory":
+		cc = cache.New()
+
+	case "kv":
+		conn, err := natsgo.Connect(nats)
+		if err != nil {
+			log.Printf("main : connecting to NATS : %s", err)
+			return
+		}
+		defer conn.Close()
+
+		kv, err := cache.NewKV(conn, bucket, sessionTTL)
+		if err != nil {
+			log.Printf("main : %s", err)
+			return
+		}
+		defer kv.Close()
+		cc = kv
+
+	default:
+		log.Printf("main : unknown session store : %s", store)
+		return
+	}
 
 	// =========================================================================
 	// Init the socket system.
 
-	evtFunc := func(evt, typ int, ipAddress string, format string, a ...any) {
-		process.Event(cc, evt, typ, ipAddress, format, a...)
-	}
-
+	// NATS and the heartbeat system are set on the request handlers once
+	// they are started.
 	reqHandler := process.ReqHandler{
-		CC: cc,
+		CC:       cc,
+		Listener: "tcp",
+	}
+	jsonHandler := process.ReqHandler{
+		CC:       cc,
+		Codec:    msg.JSON{},
+		Listener: "json",
 	}
 
 	cfg := tcp.Config{
@@ -86,33 +299,169 @@ func main() {
 		RespHandler: process.RespHandler{},
 
 		OptEvent: tcp.OptEvent{
-			Event: evtFunc,
+			Event: reqHandler.Event,
 		},
 	}
 
-	// Create a new TCP value.
+	// Create a new TCP value. The listeners only start accepting clients
+	// once NATS and the heartbeat system are set on the handlers.
 	t, err := tcp.New("Sample", cfg)
 	if err != nil {
 		log.Printf("main : %s", err)
 		return
 	}
 
-	// Start accepting client data.
-	if err := t.Start(); err != nil {
+	listeners := process.Listeners{
+		{Name: reqHandler.Listener, Conns: t, Codec: msg.Binary{}},
+	}
+
+	// Accept newline delimited JSON clients on a second listener.
+	var jt *tcp.TCP
+	if jsonHost != "" {
+		jsonCfg := cfg
+		jsonCfg.Addr = jsonHost
+		jsonCfg.ReqHandler = &jsonHandler
+		jsonCfg.OptEvent.Event = jsonHandler.Event
+
+		if jt, err = tcp.New("JSON", jsonCfg); err != nil {
+			log.Printf("main : %s", err)
+			return
+		}
+
+		listeners = append(listeners, process.Listener{Name: jsonHandler.Listener, Conns: jt, Codec: msg.JSON{}})
+	}
+
+	// Accept browser clients over WebSocket, they speak the JSON protocol.
+	ws := process.NewWebSocket(cc)
+	if wsHost != "" {
+		listeners = append(listeners, process.Listener{Name: ws.Name, Conns: ws, Codec: msg.JSON{}})
+	}
+
+	// Accept IRC clients, the gateway translates the binary protocol itself.
+	irc := process.NewIRC(cc)
+	if ircHost != "" {
+		listeners = append(listeners, process.Listener{Name: irc.Name, Conns: irc, Codec: msg.Binary{}})
+	}
+
+	// The HTTP API sends as a client connected to it. Its name is claimed in
+	// the session store so no client takes it on any node.
+	api := process.API{
+		Name:   "api",
+		Token:  apiToken,
+		Sender: apiSender,
+	}
+	var apiListener net.Listener
+	if apiHost != "" {
+		if apiListener, err = net.Listen("tcp", apiHost); err != nil {
+			log.Printf("main : %s", err)
+			return
+		}
+		defer apiListener.Close()
+
+		if err := api.Claim(cc, apiListener.Addr().(*net.TCPAddr)); err != nil {
+			log.Printf("main : %s", err)
+			return
+		}
+		defer api.Release(cc)
+
+		listeners = append(listeners, process.Listener{Name: api.Name, Conns: &api})
+	}
+
+	// =========================================================================
+	// Init the webhooks.
+
+	var hooks *webhook.Dispatcher
+	if webhooks != "" {
+		subs, err := webhook.Load(webhooks)
+		if err != nil {
+			log.Printf("main : %s", err)
+			return
+		}
+
+		whCfg := webhook.Config{
+			Subscriptions: subs,
+			Retries:       webhookRetries,
+			Backoff:       webhookBackoff,
+		}
+
+		hooks = webhook.Start(whCfg)
+		defer hooks.Stop()
+	}
+
+	// =========================================================================
+	// Init the bots.
+
+	var names []string
+	for _, name := range strings.Split(botNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	bots, err := process.BuiltinBots(names)
+	if err != nil {
 		log.Printf("main : %s", err)
 		return
 	}
-	defer t.Stop()
 
-	log.Printf("main : Waiting for data on: %s", t.Addr())
+	// =========================================================================
+	// Init the file transfers.
+
+	filesCfg := process.FilesConfig{
+		MaxSize: int64(fileMaxSize),
+		Quota:   int64(fileQuota),
+		Window:  fileWindow,
+	}
+
+	files := process.NewFiles(filesCfg)
+
+	// =========================================================================
+	// Init the history.
+
+	// Messages are only kept in memory without a file.
+	hist := history.New()
+	if historyPath != "" {
+		if hist, err = history.Open(historyPath); err != nil {
+			log.Printf("main : %s", err)
+			return
+		}
+		defer hist.Close()
+	}
+
+	var ops []string
+	for _, op := range strings.Split(operators, ",") {
+		if op = strings.TrimSpace(op); op != "" {
+			ops = append(ops, op)
+		}
+	}
 
 	// =========================================================================
 	// Init NATS.
 
+	// StartNATS connects to the NATS host unless another bus is provided.
+	var b bus.Bus
+	switch busType {
+	case "nats":
+	case "memory":
+		b = bus.NewMemory()
+		defer b.Close()
+	default:
+		log.Printf("main : unknown bus : %s", busType)
+		return
+	}
+
 	natsCfg := process.NATSConfig{
-		Host: nats,
-		CC:   cc,
-		TCP:  t,
+		Host:       nats,
+		Bus:        b,
+		Partitions: partitions,
+		CC:         cc,
+		Rooms:      cache.NewRooms(),
+		Listeners:  listeners,
+		Webhooks:   hooks,
+		Bots:       bots,
+		Files:      files,
+		History:    hist,
+		Operators:  ops,
 	}
 
 	nts, err := process.StartNATS(natsCfg)
@@ -122,8 +471,118 @@ func main() {
 	}
 	defer nts.Stop()
 
-	// Set our NATS access for the request handler.
+	// Set our NATS access for the request handlers.
 	reqHandler.NATS = nts
+	jsonHandler.NATS = nts
+	ws.NATS = nts
+	irc.NATS = nts
+
+	// =========================================================================
+	// Init the heartbeat system.
+
+	hbCfg := process.HeartbeatConfig{
+		Interval:  heartbeat,
+		MaxMissed: missed,
+		CC:        cc,
+		Listeners: listeners,
+		NATS:      nts,
+	}
+
+	hb := process.StartHeartbeat(hbCfg)
+	defer hb.Stop()
+
+	// Set our heartbeat access for the request handlers.
+	reqHandler.HB = hb
+	jsonHandler.HB = hb
+	ws.HB = hb
+	irc.HB = hb
+
+	// Clients whose name was taken by another node are disconnected.
+	if kv, ok := cc.(*cache.KV); ok {
+		kv.OnLost(func(client cache.Client) {
+			process.Lost(cc, nts, hb, client)
+		})
+	}
+
+	// =========================================================================
+	// Start accepting clients once everything is wired together.
+
+	if err := t.Start(); err != nil {
+		log.Printf("main : %s", err)
+		return
+	}
+	defer t.Stop()
+
+	log.Printf("main : Waiting for data on: %s", t.Addr())
+
+	if jt != nil {
+		if err := jt.Start(); err != nil {
+			log.Printf("main : %s", err)
+			return
+		}
+		defer jt.Stop()
+
+		log.Printf("main : Waiting for JSON data on: %s", jt.Addr())
+	}
+
+	// The web client is served from the same address as the WebSocket.
+	if wsHost != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/ws", ws)
+		mux.Handle("/", web.Handler())
+
+		srv := http.Server{
+			Addr:    wsHost,
+			Handler: mux,
+		}
+
+		go func() {
+			log.Printf("main : Waiting for WebSocket clients on: %s", wsHost)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("main : %s", err)
+			}
+		}()
+
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(ctx)
+		}()
+	}
+
+	if ircHost != "" {
+		if err := irc.Start(ircHost); err != nil {
+			log.Printf("main : %s", err)
+			return
+		}
+		defer irc.Stop()
+
+		log.Printf("main : Waiting for IRC clients on: %s", irc.Addr())
+	}
+
+	// =========================================================================
+	// Init the HTTP API.
+
+	if apiHost != "" {
+		api.NATS = nts
+
+		srv := http.Server{
+			Handler: &api,
+		}
+
+		go func() {
+			log.Printf("main : Waiting for API requests on: %s", apiListener.Addr())
+			if err := srv.Serve(apiListener); err != nil && err != http.ErrServerClosed {
+				log.Printf("main : %s", err)
+			}
+		}()
+
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(ctx)
+		}()
+	}
 
 	// =========================================================================
 	// System started.
@@ -0,0 +1,218 @@
+package process
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"chat/internal/platform/cache"
+	"chat/internal/platform/history"
+	"chat/pkg/msg"
+
+	"github.com/ardanlabs/kit/tcp"
+	"github.com/pkg/errors"
+)
+
+// maxBodyLength is the largest request body the API reads, enough for the
+// largest message once escaped.
+const maxBodyLength = 8 * msg.MaxDataLength
+
+// apiMessage is the JSON body of the requests posting messages.
+type apiMessage struct {
+	Data string `json:"data"`
+}
+
+// API lets scripts post into rooms over HTTP without a chat session:
+//
+//	POST /rooms/{room}/messages
+//	Authorization: Bearer <token>
+//	{"data": "the message"}
+//
+// The room is named without the # prefix. The message is sent on behalf of
+// the configured sender and published through NATS so it reaches the room
+// members on every node.
+//
+// It also searches the history:
+//
+//	GET /search?q={words}&user={name}&room={room}&limit={n}
+//	Authorization: Bearer <token>
+//
+// Only the conversations of the user are searched when it is given, and only
+// the room when it is. The latest messages found are returned as a JSON
+// array, up to limit, 20 by default.
+//
+// The sender is claimed in the session store like a client connected to
+// the API, so no client can log in with its name on any node sharing the
+// store. The messages sent to it are discarded.
+type API struct {
+	NATS   *NATS
+	Name   string // Name the address of the sender is tagged with.
+	Token  string // Required in the Authorization header of every request.
+	Sender string // Name the messages are sent as.
+
+	addr *net.TCPAddr
+}
+
+// Claim checks the name of the sender and takes it in the session store at
+// the address the API accepts requests on.
+func (api *API) Claim(cc cache.SessionStore, tcpAddr *net.TCPAddr) error {
+	if api.Sender == "" || len(api.Sender) > 10 || msg.IsRoom(api.Sender) {
+		return errors.Errorf("invalid API sender [ %s ]", api.Sender)
+	}
+
+	addr := listenerAddr(api.Name, tcpAddr)
+	if err := cc.Add(api.Sender, addr); err != nil {
+		return errors.Wrapf(err, "API sender [ %s ]", api.Sender)
+	}
+
+	api.addr = addr
+	return nil
+}
+
+// Release gives the name of the sender up.
+func (api *API) Release(cc cache.SessionStore) error {
+	if api.addr == nil {
+		return nil
+	}
+	return cc.Remove(api.addr.String())
+}
+
+// Send implements the Conns interface. The sender doesn't read messages.
+func (api *API) Send(ctx context.Context, r *tcp.Response) error {
+	return nil
+}
+
+// Drop implements the Conns interface. The sender can't be disconnected.
+func (api *API) Drop(tcpAddr *net.TCPAddr) error {
+	return errors.Errorf("IP[ %s ] : the API can't be dropped", tcpAddr)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/search" {
+		api.search(w, r)
+		return
+	}
+
+	name, ok := strings.CutPrefix(r.URL.Path, "/rooms/")
+	if ok {
+		name, ok = strings.CutSuffix(name, "/messages")
+	}
+	if !ok || name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !api.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	room := msg.RoomPrefix + name
+	if len(room) > 10 {
+		http.Error(w, "room name too long", http.StatusBadRequest)
+		return
+	}
+
+	var am apiMessage
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodyLength)).Decode(&am); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case am.Data == "":
+		http.Error(w, "data is required", http.StatusBadRequest)
+		return
+	case len(am.Data) > msg.MaxDataLength:
+		http.Error(w, "data too long", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	m := msg.MSG{
+		Sender:    api.Sender,
+		Recipient: room,
+		Type:      msg.Message,
+		Data:      am.Data,
+	}
+
+	log.Printf("api : IP[ %s ] : Inbound : %v\n", r.RemoteAddr, m)
+	if err := api.NATS.SendMsg(m); err != nil {
+		log.Printf("api : IP[ %s ] : ERROR : %s\n", r.RemoteAddr, err)
+		http.Error(w, "unable to send the message", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// search answers the searches of the history.
+func (api *API) search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !api.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("q") == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	var room string
+	if name := q.Get("room"); name != "" {
+		room = msg.RoomPrefix + name
+	}
+
+	limit := maxResults
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	found := api.NATS.Search(q.Get("q"), q.Get("user"), room, limit)
+	if found == nil {
+		found = []history.Message{}
+	}
+
+	log.Printf("api : IP[ %s ] : Search : Q[ %s ] User[ %s ] Room[ %s ] : %d found\n", r.RemoteAddr, q.Get("q"), q.Get("user"), room, len(found))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(found); err != nil {
+		log.Printf("api : IP[ %s ] : ERROR : %s\n", r.RemoteAddr, err)
+	}
+}
+
+// authorized reports whether the request holds the token.
+func (api *API) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || api.Token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(api.Token)) == 1
+}
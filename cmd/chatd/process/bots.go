@@ -0,0 +1,207 @@
+package process
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chat/pkg/msg"
+
+	"github.com/pkg/errors"
+)
+
+// Bot responds to the chat messages matching its pattern. Bots run inside
+// chatd and are listed online like the clients.
+type Bot struct {
+	Name    string         // Sender of the replies.
+	Pattern *regexp.Regexp // Matched against the data of the messages.
+
+	// Reply returns the reply to the message, given the submatches of the
+	// pattern. An empty reply is not sent.
+	Reply func(m msg.MSG, match []string) string
+}
+
+// Bots represents the registry of the bots.
+type Bots struct {
+	mu   sync.RWMutex
+	bots []Bot
+}
+
+// NewBots returns an empty registry.
+func NewBots() *Bots {
+	return &Bots{}
+}
+
+// Register adds the bot to the registry.
+func (bs *Bots) Register(b Bot) error {
+	if b.Name == "" || len(b.Name) > 10 || msg.IsRoom(b.Name) {
+		return errors.Errorf("invalid bot name [ %s ]", b.Name)
+	}
+	if b.Pattern == nil || b.Reply == nil {
+		return errors.Errorf("bot [ %s ] needs a pattern and a reply", b.Name)
+	}
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	for _, o := range bs.bots {
+		if o.Name == b.Name {
+			return errors.Errorf("bot [ %s ] already registered", b.Name)
+		}
+	}
+	bs.bots = append(bs.bots, b)
+
+	return nil
+}
+
+// Names returns the names of the registered bots.
+func (bs *Bots) Names() []string {
+	if bs == nil {
+		return nil
+	}
+
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	names := make([]string, len(bs.bots))
+	for i, b := range bs.bots {
+		names[i] = b.Name
+	}
+	return names
+}
+
+// Has reports whether a bot is registered with the name.
+func (bs *Bots) Has(name string) bool {
+	for _, n := range bs.Names() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Replies returns the replies of the bots matching the message. A reply is
+// sent where the message was, to the room, to everyone or back to the
+// sender of a direct message to the bot. Direct messages to others are
+// private and left alone.
+func (bs *Bots) Replies(m msg.MSG) []msg.MSG {
+	if bs == nil || m.Type != msg.Message {
+		return nil
+	}
+
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	var replies []msg.MSG
+	for _, b := range bs.bots {
+		if b.Name == m.Sender {
+			continue
+		}
+
+		recipient := m.Recipient
+		switch {
+		case recipient == b.Name:
+			recipient = m.Sender
+		case recipient != "" && !msg.IsRoom(recipient):
+			continue
+		}
+
+		match := b.Pattern.FindStringSubmatch(m.Data)
+		if match == nil {
+			continue
+		}
+
+		data := b.Reply(m, match)
+		if data == "" {
+			continue
+		}
+
+		replies = append(replies, msg.MSG{
+			Sender:    b.Name,
+			Recipient: recipient,
+			Type:      msg.Message,
+			Data:      data,
+		})
+	}
+
+	return replies
+}
+
+// =============================================================================
+
+// builtinBots holds the bots shipped with chatd.
+var builtinBots = map[string]Bot{
+
+	// !echo text, replies with the text.
+	"echo": {
+		Name:    "echobot",
+		Pattern: regexp.MustCompile(`^!echo\s+(.+)$`),
+		Reply: func(m msg.MSG, match []string) string {
+			return match[1]
+		},
+	},
+
+	// !time, replies with the time of the server.
+	"time": {
+		Name:    "timebot",
+		Pattern: regexp.MustCompile(`^!time\s*$`),
+		Reply: func(m msg.MSG, match []string) string {
+			return time.Now().UTC().Format(time.RFC1123)
+		},
+	},
+
+	// !roll [NdM], rolls N dice of M faces, a single six-sided one by default.
+	"roll": {
+		Name:    "dicebot",
+		Pattern: regexp.MustCompile(`^!roll(?:\s+(\d+)d(\d+))?\s*$`),
+		Reply:   roll,
+	},
+}
+
+// roll rolls the dice for the !roll command.
+func roll(m msg.MSG, match []string) string {
+	n, faces := 1, 6
+	if match[1] != "" {
+		n, _ = strconv.Atoi(match[1])
+		faces, _ = strconv.Atoi(match[2])
+	}
+
+	if n < 1 || n > 100 || faces < 2 || faces > 1000 {
+		return fmt.Sprintf("%s: roll between 1 and 100 dice of 2 to 1000 faces", m.Sender)
+	}
+
+	rolls := make([]string, n)
+	total := 0
+	for i := range rolls {
+		r := rand.Intn(faces) + 1
+		rolls[i] = strconv.Itoa(r)
+		total += r
+	}
+
+	return fmt.Sprintf("%s rolled %dd%d: %s = %d", m.Sender, n, faces, strings.Join(rolls, " + "), total)
+}
+
+// BuiltinBots returns a registry holding the named builtin bots: echo, time
+// and roll.
+func BuiltinBots(names []string) (*Bots, error) {
+	bs := NewBots()
+
+	for _, name := range names {
+		b, exists := builtinBots[name]
+		if !exists {
+			return nil, errors.Errorf("unknown bot [ %s ]", name)
+		}
+
+		if err := bs.Register(b); err != nil {
+			return nil, err
+		}
+		log.Printf("bots : registered : Bot[ %s ] Name[ %s ]\n", name, b.Name)
+	}
+
+	return bs, nil
+}
@@ -0,0 +1,91 @@
+package process_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"chat/cmd/chatd/process"
+	"chat/pkg/msg"
+)
+
+// TestBotReplies test that the bots answer where the command was sent.
+func TestBotReplies(t *testing.T) {
+	bs, err := process.BuiltinBots([]string{"echo", "time", "roll"})
+	if err != nil {
+		t.Fatalf("Should be able to register the builtin bots : %v", err)
+	}
+
+	t.Log("Given the need to answer commands with bots.")
+	{
+		t.Logf("\tTest 0:\tWhen the command is sent")
+		{
+			tt := []struct {
+				name      string
+				m         msg.MSG
+				recipient string
+			}{
+				{"broadcast", msg.MSG{Sender: "bill", Type: msg.Message, Data: "!echo hi"}, ""},
+				{"room", msg.MSG{Sender: "bill", Recipient: "#dev", Type: msg.Message, Data: "!echo hi"}, "#dev"},
+				{"direct", msg.MSG{Sender: "bill", Recipient: "echobot", Type: msg.Message, Data: "!echo hi"}, "bill"},
+			}
+
+			for _, tst := range tt {
+				replies := bs.Replies(tst.m)
+				if len(replies) != 1 || replies[0].Sender != "echobot" || replies[0].Recipient != tst.recipient || replies[0].Data != "hi" {
+					t.Fatalf("\t%s\tShould answer a %s command : got%v\n", failed, tst.name, replies)
+				}
+				t.Logf("\t%s\tShould answer a %s command.\n", succeed, tst.name)
+			}
+		}
+
+		t.Logf("\tTest 1:\tWhen the bots shouldn't answer")
+		{
+			tt := []struct {
+				name string
+				m    msg.MSG
+			}{
+				{"direct messages to others", msg.MSG{Sender: "bill", Recipient: "jill", Type: msg.Message, Data: "!echo hi"}},
+				{"other types", msg.MSG{Sender: "bill", Recipient: "#dev", Type: msg.JoinRoom, Data: "!echo hi"}},
+				{"unknown commands", msg.MSG{Sender: "bill", Type: msg.Message, Data: "!dance"}},
+				{"commands inside text", msg.MSG{Sender: "bill", Type: msg.Message, Data: "try !time"}},
+			}
+
+			for _, tst := range tt {
+				if replies := bs.Replies(tst.m); len(replies) != 0 {
+					t.Fatalf("\t%s\tShould ignore %s : got%v\n", failed, tst.name, replies)
+				}
+				t.Logf("\t%s\tShould ignore %s.\n", succeed, tst.name)
+			}
+		}
+
+		t.Logf("\tTest 2:\tWhen rolling dice")
+		{
+			replies := bs.Replies(msg.MSG{Sender: "bill", Type: msg.Message, Data: "!roll 3d6"})
+			if len(replies) != 1 || !regexp.MustCompile(`^bill rolled 3d6: [1-6] \+ [1-6] \+ [1-6] = \d+$`).MatchString(replies[0].Data) {
+				t.Fatalf("\t%s\tShould roll the dice : got%v\n", failed, replies)
+			}
+			t.Logf("\t%s\tShould roll the dice.\n", succeed)
+
+			replies = bs.Replies(msg.MSG{Sender: "bill", Type: msg.Message, Data: "!roll 0d6"})
+			if len(replies) != 1 || !strings.Contains(replies[0].Data, "between") {
+				t.Fatalf("\t%s\tShould refuse invalid dice : got%v\n", failed, replies)
+			}
+			t.Logf("\t%s\tShould refuse invalid dice.\n", succeed)
+		}
+
+		t.Logf("\tTest 3:\tWhen registering bots")
+		{
+			echo := process.Bot{Name: "echobot", Pattern: regexp.MustCompile(`.`), Reply: func(msg.MSG, []string) string { return "" }}
+			if err := bs.Register(echo); err == nil {
+				t.Fatalf("\t%s\tShould refuse duplicate names.\n", failed)
+			}
+			t.Logf("\t%s\tShould refuse duplicate names.\n", succeed)
+
+			if _, err := process.BuiltinBots([]string{"dance"}); err == nil {
+				t.Fatalf("\t%s\tShould refuse unknown bots.\n", failed)
+			}
+			t.Logf("\t%s\tShould refuse unknown bots.\n", succeed)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package process
+
+import (
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/pkg/errors"
+)
+
+// StartEmbeddedNATS starts an in-process nats server listening on the
+// address of the specified nats url, so a single chatd binary is a complete
+// chat server. JetStream is enabled so the key-value session store can be
+// used with it. Connect to the server using its ClientURL.
+func StartEmbeddedNATS(host string, storeDir string) (*server.Server, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing NATS host : %s", host)
+	}
+
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing NATS port : %s", host)
+	}
+
+	// Like net.Listen, a zero port picks a random one.
+	if port == 0 {
+		port = server.RANDOM_PORT
+	}
+
+	opts := server.Options{
+		Host:      u.Hostname(),
+		Port:      port,
+		JetStream: true,
+		StoreDir:  storeDir,
+		NoSigs:    true,
+	}
+
+	ns, err := server.NewServer(&opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating embedded NATS")
+	}
+
+	go ns.Start()
+
+	if !ns.ReadyForConnections(5 * time.Second) {
+		ns.Shutdown()
+		return nil, errors.New("embedded NATS not ready for connections")
+	}
+
+	log.Printf("nats : embedded server started : Host[ %s ]\n", net.JoinHostPort(opts.Host, strconv.Itoa(ns.Addr().(*net.TCPAddr).Port)))
+	return ns, nil
+}
@@ -0,0 +1,230 @@
+package process
+
+import (
+	"sync"
+	"time"
+
+	"chat/pkg/msg"
+
+	"github.com/pkg/errors"
+)
+
+// errUnknownTransfer is returned for the file messages of a transfer that
+// was not offered, or not to the client.
+var errUnknownTransfer = errors.New("unknown transfer")
+
+// transferTTL is how long an offered transfer is tracked before it is
+// considered abandoned.
+const transferTTL = time.Hour
+
+// FilesConfig represents required configuration for the file transfers.
+type FilesConfig struct {
+	MaxSize int64         // Largest file that can be offered.
+	Quota   int64         // Bytes a client can offer during the window, zero for no quota.
+	Window  time.Duration // Period the quota applies to.
+}
+
+// transfer represents a file being sent by a client of this node.
+type transfer struct {
+	recipient string
+	size      int64
+	remaining int64
+	accepted  bool
+	offered   time.Time
+}
+
+// usage represents the bytes offered by a client during the window.
+type usage struct {
+	start time.Time
+	used  int64
+}
+
+// Files holds the file transfers to the size limits and quotas. Transfers
+// go through the node the sender is connected to, which keeps track of
+// them.
+type Files struct {
+	Config FilesConfig
+
+	mu        sync.Mutex
+	transfers map[string]*transfer
+	usage     map[string]*usage
+}
+
+// NewFiles returns a Files value enforcing the configuration.
+func NewFiles(cfg FilesConfig) *Files {
+	return &Files{
+		Config:    cfg,
+		transfers: make(map[string]*transfer),
+		usage:     make(map[string]*usage),
+	}
+}
+
+// Check reports why the file message can't be sent, if it can't. Offers
+// can't be larger than what is left of the quota of the sender, and chunks
+// only go to the recipient of an accepted transfer without carrying more
+// data than offered. Other messages are left alone, and so is everything
+// when there is no Files value.
+func (fs *Files) Check(m msg.MSG) error {
+	if fs == nil {
+		return nil
+	}
+
+	switch m.Type {
+	case msg.FileOffer, msg.FileAccept, msg.FileReject, msg.FileChunk:
+		if m.Recipient == "" || msg.IsRoom(m.Recipient) {
+			return errors.New("files can only be sent to a client")
+		}
+	}
+
+	switch m.Type {
+	case msg.FileOffer:
+		o, err := msg.ParseOffer(m.Data)
+		if err != nil {
+			return err
+		}
+		return fs.offer(m.Sender, m.Recipient, o)
+
+	case msg.FileChunk:
+		c, err := msg.ParseChunk(m.Data)
+		if err != nil {
+			return err
+		}
+		return fs.chunk(m.Sender, m.Recipient, c)
+	}
+
+	return nil
+}
+
+// Answer reports why the answer to a file offered by a client of this node
+// refuses the transfer, if it does. Accepted transfers count against the
+// quota of the sender of the file, rejected ones are forgotten.
+func (fs *Files) Answer(m msg.MSG) error {
+	if fs == nil {
+		return nil
+	}
+
+	id, _ := msg.ParseFileReply(m.Data)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	key := m.Recipient + "/" + id
+	t, exists := fs.transfers[key]
+	if !exists || t.recipient != m.Sender {
+		if m.Type == msg.FileReject {
+			return nil
+		}
+		return errUnknownTransfer
+	}
+
+	if m.Type == msg.FileReject {
+		delete(fs.transfers, key)
+		return nil
+	}
+
+	if t.accepted {
+		return nil
+	}
+
+	if fs.Config.Quota > 0 {
+		u := fs.use(m.Recipient, time.Now())
+		if u.used+t.size > fs.Config.Quota {
+			delete(fs.transfers, key)
+			return errors.Errorf("quota of %d bytes exceeded, %d bytes left", fs.Config.Quota, fs.Config.Quota-u.used)
+		}
+		u.used += t.size
+	}
+
+	// No chunks are sent for empty files.
+	if t.remaining == 0 {
+		delete(fs.transfers, key)
+		return nil
+	}
+	t.accepted = true
+
+	return nil
+}
+
+// offer starts tracking the transfer if it is within the limits.
+func (fs *Files) offer(sender string, recipient string, o msg.Offer) error {
+	if o.Size > fs.Config.MaxSize {
+		return errors.Errorf("file larger than %d bytes", fs.Config.MaxSize)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	now := time.Now()
+	fs.prune(now)
+
+	key := sender + "/" + o.ID
+	if _, exists := fs.transfers[key]; exists {
+		return errors.New("transfer already offered")
+	}
+
+	// The quota is only used once the transfer is accepted, offers larger
+	// than what is left are refused right away.
+	if fs.Config.Quota > 0 {
+		u := fs.use(sender, now)
+		if u.used+o.Size > fs.Config.Quota {
+			return errors.Errorf("quota of %d bytes exceeded, %d bytes left", fs.Config.Quota, fs.Config.Quota-u.used)
+		}
+	}
+
+	fs.transfers[key] = &transfer{recipient: recipient, size: o.Size, remaining: o.Size, offered: now}
+	return nil
+}
+
+// use returns the usage of the quota by the sender in the current window.
+func (fs *Files) use(sender string, now time.Time) *usage {
+	u, exists := fs.usage[sender]
+	if !exists || now.Sub(u.start) >= fs.Config.Window {
+		u = &usage{start: now}
+		fs.usage[sender] = u
+	}
+	return u
+}
+
+// chunk accounts for the data of the chunk in the transfer.
+func (fs *Files) chunk(sender string, recipient string, c msg.Chunk) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	key := sender + "/" + c.ID
+	t, exists := fs.transfers[key]
+	if !exists || t.recipient != recipient {
+		return errUnknownTransfer
+	}
+
+	if !t.accepted {
+		return errors.New("transfer not accepted")
+	}
+
+	size := int64(len(c.Data))
+	if size > t.remaining {
+		delete(fs.transfers, key)
+		return errors.New("more data than offered")
+	}
+
+	t.remaining -= size
+	if t.remaining == 0 {
+		delete(fs.transfers, key)
+	}
+
+	return nil
+}
+
+// prune forgets the abandoned transfers and the expired quotas.
+func (fs *Files) prune(now time.Time) {
+	for key, t := range fs.transfers {
+		if now.Sub(t.offered) >= transferTTL {
+			delete(fs.transfers, key)
+		}
+	}
+
+	for sender, u := range fs.usage {
+		if now.Sub(u.start) >= fs.Config.Window {
+			delete(fs.usage, sender)
+		}
+	}
+}
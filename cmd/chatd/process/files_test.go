@@ -0,0 +1,117 @@
+package process_test
+
+import (
+	"testing"
+	"time"
+
+	"chat/cmd/chatd/process"
+	"chat/pkg/msg"
+)
+
+// TestFilesCheck test that file transfers are held to the limits.
+func TestFilesCheck(t *testing.T) {
+	cfg := process.FilesConfig{
+		MaxSize: 100,
+		Quota:   150,
+		Window:  time.Hour,
+	}
+	fs := process.NewFiles(cfg)
+
+	offer := func(id string, size int64) msg.MSG {
+		o := msg.Offer{ID: id, Size: size, Checksum: "abcd", Name: "notes.txt"}
+		return msg.MSG{Sender: "bill", Recipient: "jill", Type: msg.FileOffer, Data: o.String()}
+	}
+	chunk := func(id string, seq int, size int) msg.MSG {
+		c := msg.Chunk{ID: id, Seq: seq, Data: make([]byte, size)}
+		return msg.MSG{Sender: "bill", Recipient: "jill", Type: msg.FileChunk, Data: c.String()}
+	}
+	answer := func(id string, from string, typ uint8) msg.MSG {
+		return msg.MSG{Sender: from, Recipient: "bill", Type: typ, Data: msg.FileReply(id, "")}
+	}
+
+	t.Log("Given the need to limit file transfers.")
+	{
+		t.Logf("\tTest 0:\tWhen offering files")
+		{
+			if err := fs.Check(offer("a", 101)); err == nil {
+				t.Fatalf("\t%s\tShould refuse files over the size limit.", failed)
+			}
+			t.Logf("\t%s\tShould refuse files over the size limit.", succeed)
+
+			if err := fs.Check(offer("a", 100)); err != nil {
+				t.Fatalf("\t%s\tShould accept files within the limits : %v", failed, err)
+			}
+			if err := fs.Check(offer("b", 60)); err != nil {
+				t.Fatalf("\t%s\tShould accept files within the limits : %v", failed, err)
+			}
+			t.Logf("\t%s\tShould accept files within the limits.", succeed)
+
+			m := offer("c", 10)
+			m.Recipient = "#dev"
+			if err := fs.Check(m); err == nil {
+				t.Fatalf("\t%s\tShould refuse files sent to rooms.", failed)
+			}
+			t.Logf("\t%s\tShould refuse files sent to rooms.", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen answering offers")
+		{
+			if err := fs.Check(chunk("a", 0, 10)); err == nil {
+				t.Fatalf("\t%s\tShould refuse chunks before the transfer is accepted.", failed)
+			}
+			t.Logf("\t%s\tShould refuse chunks before the transfer is accepted.", succeed)
+
+			if err := fs.Answer(answer("a", "bob", msg.FileAccept)); err == nil {
+				t.Fatalf("\t%s\tShould refuse accepts from another client.", failed)
+			}
+			t.Logf("\t%s\tShould refuse accepts from another client.", succeed)
+
+			if err := fs.Answer(answer("a", "jill", msg.FileAccept)); err != nil {
+				t.Fatalf("\t%s\tShould accept transfers within the quota : %v", failed, err)
+			}
+			t.Logf("\t%s\tShould accept transfers within the quota.", succeed)
+
+			if err := fs.Answer(answer("b", "jill", msg.FileAccept)); err == nil {
+				t.Fatalf("\t%s\tShould refuse transfers over the quota once accepted.", failed)
+			}
+			if err := fs.Check(offer("d", 60)); err == nil {
+				t.Fatalf("\t%s\tShould refuse offers over what is left of the quota.", failed)
+			}
+			t.Logf("\t%s\tShould charge the quota once accepted.", succeed)
+
+			fs.Check(offer("e", 10))
+			if err := fs.Answer(answer("e", "jill", msg.FileReject)); err != nil {
+				t.Fatalf("\t%s\tShould let transfers be rejected : %v", failed, err)
+			}
+			if err := fs.Check(chunk("e", 0, 10)); err == nil {
+				t.Fatalf("\t%s\tShould forget rejected transfers.", failed)
+			}
+			t.Logf("\t%s\tShould forget rejected transfers.", succeed)
+		}
+
+		t.Logf("\tTest 2:\tWhen sending chunks")
+		{
+			if err := fs.Check(chunk("a", 0, 60)); err != nil {
+				t.Fatalf("\t%s\tShould accept chunks of accepted files : %v", failed, err)
+			}
+			t.Logf("\t%s\tShould accept chunks of accepted files.", succeed)
+
+			m := chunk("a", 1, 10)
+			m.Recipient = "bob"
+			if err := fs.Check(m); err == nil {
+				t.Fatalf("\t%s\tShould refuse chunks sent to another client.", failed)
+			}
+			t.Logf("\t%s\tShould refuse chunks sent to another client.", succeed)
+
+			if err := fs.Check(chunk("a", 1, 41)); err == nil {
+				t.Fatalf("\t%s\tShould refuse more data than offered.", failed)
+			}
+			t.Logf("\t%s\tShould refuse more data than offered.", succeed)
+
+			if err := fs.Check(chunk("z", 0, 1)); err == nil {
+				t.Fatalf("\t%s\tShould refuse chunks of unknown transfers.", failed)
+			}
+			t.Logf("\t%s\tShould refuse chunks of unknown transfers.", succeed)
+		}
+	}
+}
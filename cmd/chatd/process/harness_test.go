@@ -0,0 +1,465 @@
+package process_test
+
+import (
+	"bufio"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chat/cmd/chatd/process"
+	"chat/internal/platform/bus"
+	"chat/internal/platform/cache"
+	"chat/pkg/msg"
+
+	"github.com/ardanlabs/kit/tcp"
+	"github.com/gorilla/websocket"
+)
+
+// wait is how long the harness waits for something to happen.
+const wait = 2 * time.Second
+
+// node represents a chatd instance running in-process.
+type node struct {
+	CC   *cache.Cache
+	NATS *process.NATS
+	HB   *process.Heartbeat
+
+	tcp     *tcp.TCP
+	jsonTCP *tcp.TCP
+	ws      *httptest.Server
+	irc     *process.IRC
+}
+
+// startNode boots a chatd instance using the specified bus. It accepts
+// binary, JSON, WebSocket and IRC clients, each on a random port. A nil bus
+// connects to the nats server at host instead. The heartbeat is only
+// started for a non zero interval.
+func startNode(t *testing.T, b bus.Bus, host string, heartbeat time.Duration) *node {
+	t.Helper()
+
+	n := node{
+		CC: cache.New(),
+	}
+
+	reqHandler := process.ReqHandler{
+		CC:       n.CC,
+		Listener: "tcp",
+	}
+	jsonHandler := process.ReqHandler{
+		CC:       n.CC,
+		Codec:    msg.JSON{},
+		Listener: "json",
+	}
+
+	cfg := tcp.Config{
+		NetType: "tcp4",
+		Addr:    "127.0.0.1:0",
+
+		ConnHandler: process.ConnHandler{},
+		ReqHandler:  &reqHandler,
+		RespHandler: process.RespHandler{},
+
+		OptEvent: tcp.OptEvent{
+			Event: reqHandler.Event,
+		},
+	}
+
+	var err error
+	if n.tcp, err = tcp.New("Test", cfg); err != nil {
+		t.Fatalf("Should be able to create the TCP value : %v", err)
+	}
+
+	jsonCfg := cfg
+	jsonCfg.ReqHandler = &jsonHandler
+	jsonCfg.OptEvent.Event = jsonHandler.Event
+	if n.jsonTCP, err = tcp.New("TestJSON", jsonCfg); err != nil {
+		t.Fatalf("Should be able to create the JSON TCP value : %v", err)
+	}
+
+	ws := process.NewWebSocket(n.CC)
+	n.irc = process.NewIRC(n.CC)
+
+	natsCfg := process.NATSConfig{
+		Host:  host,
+		Bus:   b,
+		CC:    n.CC,
+		Rooms: cache.NewRooms(),
+		Listeners: process.Listeners{
+			{Name: reqHandler.Listener, Conns: n.tcp, Codec: msg.Binary{}},
+			{Name: jsonHandler.Listener, Conns: n.jsonTCP, Codec: msg.JSON{}},
+			{Name: ws.Name, Conns: ws, Codec: msg.JSON{}},
+			{Name: n.irc.Name, Conns: n.irc, Codec: msg.Binary{}},
+		},
+	}
+
+	if n.NATS, err = process.StartNATS(natsCfg); err != nil {
+		t.Fatalf("Should be able to start NATS : %v", err)
+	}
+	t.Cleanup(n.NATS.Stop)
+	reqHandler.NATS = n.NATS
+	jsonHandler.NATS = n.NATS
+	ws.NATS = n.NATS
+	n.irc.NATS = n.NATS
+
+	if heartbeat > 0 {
+		hbCfg := process.HeartbeatConfig{
+			Interval:  heartbeat,
+			MaxMissed: 1,
+			CC:        n.CC,
+			Listeners: natsCfg.Listeners,
+			NATS:      n.NATS,
+		}
+
+		n.HB = process.StartHeartbeat(hbCfg)
+		t.Cleanup(n.HB.Stop)
+		reqHandler.HB = n.HB
+		jsonHandler.HB = n.HB
+		ws.HB = n.HB
+		n.irc.HB = n.HB
+	}
+
+	// Start accepting clients once everything is wired together.
+	for _, l := range []*tcp.TCP{n.tcp, n.jsonTCP} {
+		l := l
+		if err := l.Start(); err != nil {
+			t.Fatalf("Should be able to start the TCP value : %v", err)
+		}
+		t.Cleanup(func() { l.Stop() })
+	}
+
+	n.ws = httptest.NewServer(ws)
+	t.Cleanup(n.ws.Close)
+
+	if err := n.irc.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Should be able to start the IRC gateway : %v", err)
+	}
+	t.Cleanup(n.irc.Stop)
+
+	return &n
+}
+
+// Addr returns the address binary clients connect to.
+func (n *node) Addr() string {
+	return n.tcp.Addr().String()
+}
+
+// JSONAddr returns the address JSON clients connect to.
+func (n *node) JSONAddr() string {
+	return n.jsonTCP.Addr().String()
+}
+
+// startEmbedded boots an embedded nats server on a random port and returns
+// the url to connect to it.
+func startEmbedded(t *testing.T) string {
+	t.Helper()
+
+	ns, err := process.StartEmbeddedNATS("nats://127.0.0.1:0", t.TempDir())
+	if err != nil {
+		t.Fatalf("Should be able to start the embedded NATS : %v", err)
+	}
+	t.Cleanup(ns.Shutdown)
+
+	return ns.ClientURL()
+}
+
+// =============================================================================
+
+// client represents a scripted chat client.
+type client struct {
+	Name string
+
+	t     *testing.T
+	conn  net.Conn
+	ws    *websocket.Conn
+	codec msg.Codec
+	recv  chan msg.MSG
+}
+
+// connect dials the node and logs in with the specified name using the
+// binary protocol. It waits for the node to register the client unless the
+// name is expected to be taken.
+func connect(t *testing.T, n *node, name string, taken bool) *client {
+	t.Helper()
+	return dial(t, n, n.Addr(), msg.Binary{}, name, taken)
+}
+
+// connectJSON is like connect but speaks the JSON protocol.
+func connectJSON(t *testing.T, n *node, name string) *client {
+	t.Helper()
+	return dial(t, n, n.JSONAddr(), msg.JSON{}, name, false)
+}
+
+// dial connects to the address using the codec and logs in.
+func dial(t *testing.T, n *node, addr string, codec msg.Codec, name string, taken bool) *client {
+	t.Helper()
+
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatalf("Should be able to connect [ %s ] : %v", name, err)
+	}
+
+	return login(t, n, conn, codec, name, taken)
+}
+
+// login logs in with the specified name over the connection.
+func login(t *testing.T, n *node, conn net.Conn, codec msg.Codec, name string, taken bool) *client {
+	t.Helper()
+
+	c := client{
+		Name:  name,
+		t:     t,
+		conn:  conn,
+		codec: codec,
+		recv:  make(chan msg.MSG, 100),
+	}
+	t.Cleanup(func() { c.conn.Close() })
+
+	go func() {
+		defer close(c.recv)
+
+		r := bufio.NewReader(conn)
+		for {
+			m, err := codec.Read(r)
+			if err != nil {
+				return
+			}
+			c.recv <- m
+		}
+	}()
+
+	c.Send(msg.MSG{Type: msg.Init})
+
+	if !taken {
+		eventually(t, "client [ "+name+" ] registered", func() bool {
+			_, err := n.CC.GetID(name)
+			return err == nil
+		})
+	}
+
+	return &c
+}
+
+// connectWS is like connect but speaks JSON over a WebSocket.
+func connectWS(t *testing.T, n *node, name string) *client {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(n.ws.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Should be able to connect [ %s ] : %v", name, err)
+	}
+
+	c := client{
+		Name:  name,
+		t:     t,
+		ws:    ws,
+		codec: msg.JSON{},
+		recv:  make(chan msg.MSG, 100),
+	}
+	t.Cleanup(func() { c.ws.Close() })
+
+	go func() {
+		defer close(c.recv)
+
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			m, err := msg.DecodeJSON(data)
+			if err != nil {
+				return
+			}
+			c.recv <- m
+		}
+	}()
+
+	c.Send(msg.MSG{Type: msg.Init})
+
+	eventually(t, "client [ "+name+" ] registered", func() bool {
+		_, err := n.CC.GetID(name)
+		return err == nil
+	})
+
+	return &c
+}
+
+// Send writes the message from this client.
+func (c *client) Send(m msg.MSG) {
+	c.t.Helper()
+	c.SendAs(c.Name, m)
+}
+
+// SendAs writes the message claiming it is from the sender, whoever the
+// client registered as.
+func (c *client) SendAs(sender string, m msg.MSG) {
+	c.t.Helper()
+
+	m.Sender = sender
+	data := c.codec.Encode(m)
+
+	var err error
+	if c.ws != nil {
+		err = c.ws.WriteMessage(websocket.TextMessage, data)
+	} else {
+		_, err = c.conn.Write(data)
+	}
+	if err != nil {
+		c.t.Fatalf("Should be able to send from [ %s ] : %v", c.Name, err)
+	}
+}
+
+// Close disconnects the client.
+func (c *client) Close() {
+	if c.ws != nil {
+		c.ws.Close()
+		return
+	}
+	c.conn.Close()
+}
+
+// Expect waits for the next message of the specified type, skipping any
+// other message.
+func (c *client) Expect(typ uint8) msg.MSG {
+	c.t.Helper()
+
+	timeout := time.After(wait)
+	for {
+		select {
+		case m, ok := <-c.recv:
+			if !ok {
+				c.t.Fatalf("Client [ %s ] disconnected waiting for type %d", c.Name, typ)
+			}
+			if m.Type == typ {
+				return m
+			}
+		case <-timeout:
+			c.t.Fatalf("Client [ %s ] timed out waiting for type %d", c.Name, typ)
+		}
+	}
+}
+
+// ExpectNone makes sure no message of the specified type is received for
+// a short while.
+func (c *client) ExpectNone(typ uint8) {
+	c.t.Helper()
+
+	timeout := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case m, ok := <-c.recv:
+			if !ok {
+				return
+			}
+			if m.Type == typ {
+				c.t.Fatalf("Client [ %s ] should not have received : %v", c.Name, m)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// Disconnected waits for the node to close the connection.
+func (c *client) Disconnected() bool {
+	timeout := time.After(wait)
+	for {
+		select {
+		case _, ok := <-c.recv:
+			if !ok {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// =============================================================================
+
+// ircClient represents a scripted IRC client.
+type ircClient struct {
+	t     *testing.T
+	conn  net.Conn
+	lines chan string
+}
+
+// connectIRC dials the IRC gateway of the node and registers the nick.
+func connectIRC(t *testing.T, n *node, nick string) *ircClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp4", n.irc.Addr().String())
+	if err != nil {
+		t.Fatalf("Should be able to connect [ %s ] : %v", nick, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := ircClient{
+		t:     t,
+		conn:  conn,
+		lines: make(chan string, 100),
+	}
+
+	go func() {
+		defer close(c.lines)
+
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			c.lines <- strings.TrimRight(scanner.Text(), "\r")
+		}
+	}()
+
+	c.Send("NICK " + nick)
+	c.Send("USER " + nick + " 0 * :" + nick)
+	c.Expect(" 001 ")
+
+	eventually(t, "client [ "+nick+" ] registered", func() bool {
+		_, err := n.CC.GetID(nick)
+		return err == nil
+	})
+
+	return &c
+}
+
+// Send writes the line to the gateway.
+func (c *ircClient) Send(line string) {
+	c.t.Helper()
+
+	if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+		c.t.Fatalf("Should be able to send [ %s ] : %v", line, err)
+	}
+}
+
+// Expect waits for the next line holding the text, skipping any other line.
+func (c *ircClient) Expect(text string) string {
+	c.t.Helper()
+
+	timeout := time.After(wait)
+	for {
+		select {
+		case line, ok := <-c.lines:
+			if !ok {
+				c.t.Fatalf("IRC client disconnected waiting for [ %s ]", text)
+			}
+			if strings.Contains(line, text) {
+				return line
+			}
+		case <-timeout:
+			c.t.Fatalf("IRC client timed out waiting for [ %s ]", text)
+		}
+	}
+}
+
+// eventually polls the condition until it is true or the wait is over.
+func eventually(t *testing.T, what string, f func() bool) {
+	t.Helper()
+
+	for start := time.Now(); time.Since(start) < wait; time.Sleep(10 * time.Millisecond) {
+		if f() {
+			return
+		}
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
@@ -0,0 +1,169 @@
+package process
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"chat/internal/platform/cache"
+	"chat/pkg/msg"
+)
+
+// peer represents the heartbeat state of a single connection.
+type peer struct {
+	tcpAddr  *net.TCPAddr
+	lastSeen time.Time
+	missed   int
+}
+
+// HeartbeatConfig represents required configuration for the heartbeat system.
+type HeartbeatConfig struct {
+	Interval  time.Duration // How long a connection can be idle before it is pinged.
+	MaxMissed int           // Number of unanswered pings before the client is evicted.
+	CC        cache.SessionStore
+	Listeners Listeners
+	NATS      *NATS
+}
+
+// Heartbeat pings idle connections and evicts clients that stop answering.
+type Heartbeat struct {
+	Config HeartbeatConfig
+
+	mu    sync.Mutex
+	peers map[string]*peer
+
+	wg       sync.WaitGroup
+	shutdown chan struct{}
+}
+
+// StartHeartbeat initializes the heartbeat system and starts the loop
+// that pings idle connections.
+func StartHeartbeat(cfg HeartbeatConfig) *Heartbeat {
+	hb := Heartbeat{
+		Config:   cfg,
+		peers:    make(map[string]*peer),
+		shutdown: make(chan struct{}),
+	}
+
+	hb.wg.Add(1)
+	go func() {
+		defer hb.wg.Done()
+
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				hb.beat()
+			case <-hb.shutdown:
+				return
+			}
+		}
+	}()
+
+	log.Printf("heartbeat : service started : Interval[ %v ] MaxMissed[ %d ]\n", cfg.Interval, cfg.MaxMissed)
+	return &hb
+}
+
+// Stop shutdowns the heartbeat loop.
+func (hb *Heartbeat) Stop() {
+	if hb == nil {
+		return
+	}
+
+	close(hb.shutdown)
+	hb.wg.Wait()
+
+	log.Println("heartbeat : service stopped")
+}
+
+// Track starts watching the connection for the specified address.
+func (hb *Heartbeat) Track(tcpAddr *net.TCPAddr) {
+	if hb == nil {
+		return
+	}
+
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	hb.peers[tcpAddr.String()] = &peer{
+		tcpAddr:  tcpAddr,
+		lastSeen: time.Now(),
+	}
+}
+
+// Seen records activity for the specified address.
+func (hb *Heartbeat) Seen(address string) {
+	if hb == nil {
+		return
+	}
+
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	if p, exists := hb.peers[address]; exists {
+		p.lastSeen = time.Now()
+		p.missed = 0
+	}
+}
+
+// Forget stops watching the connection for the specified address.
+func (hb *Heartbeat) Forget(address string) {
+	if hb == nil {
+		return
+	}
+
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	delete(hb.peers, address)
+}
+
+// beat pings every idle connection and evicts the ones that missed too
+// many pings.
+func (hb *Heartbeat) beat() {
+	var ping, evict []*net.TCPAddr
+
+	hb.mu.Lock()
+	{
+		now := time.Now()
+		for address, p := range hb.peers {
+			if now.Sub(p.lastSeen) < hb.Config.Interval {
+				continue
+			}
+
+			if p.missed >= hb.Config.MaxMissed {
+				delete(hb.peers, address)
+				evict = append(evict, p.tcpAddr)
+				continue
+			}
+
+			p.missed++
+			ping = append(ping, p.tcpAddr)
+		}
+	}
+	hb.mu.Unlock()
+
+	for _, tcpAddr := range ping {
+		forwardTCPResponse(tcpAddr, msg.MSG{Type: msg.Ping}, hb.Config.Listeners)
+	}
+
+	for _, tcpAddr := range evict {
+		hb.evict(tcpAddr)
+	}
+}
+
+// evict removes the client from the cache, announces it left and drops its
+// connection.
+func (hb *Heartbeat) evict(tcpAddr *net.TCPAddr) {
+	address := tcpAddr.String()
+	log.Printf("heartbeat : IP[ %s ] : evicting unresponsive connection\n", address)
+
+	Leave(hb.Config.CC, hb.Config.NATS, hb, address)
+
+	if err := hb.Config.Listeners.Drop(tcpAddr); err != nil {
+		log.Printf("heartbeat : IP[ %s ] : ERROR : drop : %s\n", address, err)
+	}
+}
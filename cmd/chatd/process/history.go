@@ -0,0 +1,230 @@
+package process
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"chat/internal/platform/history"
+	"chat/pkg/msg"
+
+	"github.com/pkg/errors"
+)
+
+// record gives the chat message an id unless the client did and stores it
+// in the history. A message with an id that is already used is refused, the
+// sender would change the other message with it.
+func (nts *NATS) record(m *msg.MSG) error {
+	if m.ID == "" {
+		m.ID = msg.NewID()
+	}
+
+	if nts.Config.History == nil {
+		return nil
+	}
+
+	hm := history.Message{
+		ID:        m.ID,
+		Sender:    m.Sender,
+		Recipient: m.Recipient,
+		ReplyTo:   m.ReplyTo,
+		Data:      m.Data,
+		Time:      time.Now().UTC(),
+	}
+
+	switch err := nts.Config.History.Add(hm); err {
+	case nil:
+		nts.replicate(*m, hm.Time)
+	case history.ErrExists:
+		return errors.Wrapf(err, "ID[ %s ]", m.ID)
+	default:
+		log.Printf("Nats_Process : IP[ nats ] : ERROR : history : %s\n", err)
+	}
+
+	return nil
+}
+
+// amend applies the edit or delete to the history once the sender is known
+// to be allowed to change the message, the original sender or an operator.
+// The change is sent where the message was.
+func (nts *NATS) amend(m msg.MSG) (msg.MSG, error) {
+	if nts.Config.History == nil {
+		return m, errors.New("no history to change")
+	}
+
+	hm, err := nts.Config.History.Get(m.ID)
+	if err != nil {
+		return m, errors.Wrapf(err, "ID[ %s ]", m.ID)
+	}
+
+	if hm.Sender != m.Sender && !nts.IsOperator(m.Sender) {
+		return m, errors.Errorf("ID[ %s ] : only [ %s ] or an operator can change the message", m.ID, hm.Sender)
+	}
+
+	now := time.Now().UTC()
+	switch m.Type {
+	case msg.Edit:
+		err = nts.Config.History.Edit(m.ID, m.Data, now)
+	case msg.Delete:
+		m.Data = ""
+		err = nts.Config.History.Delete(m.ID, now)
+	}
+	if err != nil {
+		return m, errors.Wrapf(err, "ID[ %s ]", m.ID)
+	}
+
+	nts.replicate(m, now)
+
+	m.Recipient = hm.Recipient
+	return m, nil
+}
+
+// react applies the reaction to the history and sends it where the message
+// was with the number of reactions with the emoji. Only the sender and the
+// recipient of a direct message can react to it.
+func (nts *NATS) react(m msg.MSG) (msg.MSG, error) {
+	if nts.Config.History == nil {
+		return m, errors.New("no history to react to")
+	}
+
+	r, err := msg.ParseReaction(m.Data)
+	if err != nil {
+		return m, errors.Wrapf(err, "ID[ %s ]", m.ID)
+	}
+
+	hm, err := nts.Config.History.Get(m.ID)
+	if err != nil {
+		return m, errors.Wrapf(err, "ID[ %s ]", m.ID)
+	}
+
+	direct := hm.Recipient != "" && !msg.IsRoom(hm.Recipient)
+	if direct && m.Sender != hm.Sender && m.Sender != hm.Recipient {
+		return m, errors.Errorf("ID[ %s ] : only [ %s ] and [ %s ] can react to the message", m.ID, hm.Sender, hm.Recipient)
+	}
+
+	count, err := nts.Config.History.React(m.ID, m.Sender, r.Emoji)
+	if err != nil {
+		return m, errors.Wrapf(err, "ID[ %s ]", m.ID)
+	}
+
+	// The recipient of a direct message reacts to its sender.
+	m.Recipient = hm.Recipient
+	if direct && m.Sender == hm.Recipient {
+		m.Recipient = hm.Sender
+	}
+	m.Data = msg.Reaction{Emoji: r.Emoji, Count: count}.String()
+	nts.replicate(m, time.Now().UTC())
+
+	return m, nil
+}
+
+// read marks the direct message read once its recipient says so, and sends
+// the receipt to the sender of the message. Only the first receipt of a
+// message is sent.
+func (nts *NATS) read(m msg.MSG) (msg.MSG, error) {
+	if nts.Config.History == nil {
+		return m, errors.New("no history to mark read")
+	}
+
+	hm, err := nts.Config.History.Get(m.ID)
+	if err != nil {
+		return m, errors.Wrapf(err, "ID[ %s ]", m.ID)
+	}
+
+	if hm.Recipient == "" || msg.IsRoom(hm.Recipient) || hm.Recipient != m.Sender {
+		return m, errors.Errorf("ID[ %s ] : only the recipient of a direct message can read it", m.ID)
+	}
+
+	now := time.Now().UTC()
+	first, err := nts.Config.History.MarkRead(m.ID, now)
+	if err != nil {
+		return m, errors.Wrapf(err, "ID[ %s ]", m.ID)
+	}
+	if !first {
+		return m, errors.Errorf("ID[ %s ] : already read", m.ID)
+	}
+	nts.replicate(m, now)
+
+	m.Recipient = hm.Sender
+	m.Data = ""
+	return m, nil
+}
+
+// change represents a change made to the history of a node. It is sent to
+// the other nodes, so every node holds the messages of the whole cluster.
+type change struct {
+	Node string    `json:"node"`
+	MSG  msg.MSG   `json:"msg"`
+	Time time.Time `json:"time"`
+}
+
+// replicate sends the change made to the history to the other nodes.
+func (nts *NATS) replicate(m msg.MSG, t time.Time) {
+	data, err := json.Marshal(change{Node: nts.id, MSG: m, Time: t})
+	if err != nil {
+		log.Printf("Nats_Process : IP[ nats ] : ERROR : history : %s\n", err)
+		return
+	}
+
+	if err := nts.bus.Publish(natsHistory, data); err != nil {
+		log.Printf("Nats_Process : IP[ nats ] : ERROR : history : %s\n", err)
+	}
+}
+
+// apply makes the change another node made to its history. The change was
+// allowed by that node, it is not checked again.
+func (nts *NATS) apply(data []byte) {
+	if nts.Config.History == nil {
+		return
+	}
+
+	var c change
+	if err := json.Unmarshal(data, &c); err != nil {
+		log.Printf("Nats_Process : IP[ nats ] : ERROR : history : %s\n", err)
+		return
+	}
+	if c.Node == nts.id {
+		return
+	}
+
+	h := nts.Config.History
+	m := c.MSG
+
+	var err error
+	switch m.Type {
+	case msg.Message:
+		err = h.Add(history.Message{
+			ID:        m.ID,
+			Sender:    m.Sender,
+			Recipient: m.Recipient,
+			ReplyTo:   m.ReplyTo,
+			Data:      m.Data,
+			Time:      c.Time,
+		})
+	case msg.Edit:
+		err = h.Edit(m.ID, m.Data, c.Time)
+	case msg.Delete:
+		err = h.Delete(m.ID, c.Time)
+	case msg.React:
+		var r msg.Reaction
+		if r, err = msg.ParseReaction(m.Data); err == nil {
+			_, err = h.React(m.ID, m.Sender, r.Emoji)
+		}
+	case msg.ReadReceipt:
+		_, err = h.MarkRead(m.ID, c.Time)
+	}
+
+	if err != nil {
+		log.Printf("Nats_Process : IP[ nats ] : ERROR : history : ID[ %s ] : %s\n", m.ID, err)
+	}
+}
+
+// IsOperator reports whether the client is an operator.
+func (nts *NATS) IsOperator(name string) bool {
+	for _, op := range nts.Config.Operators {
+		if op == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,641 @@
+package process
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"chat/internal/platform/cache"
+	"chat/pkg/msg"
+
+	"github.com/ardanlabs/kit/tcp"
+	"github.com/pkg/errors"
+)
+
+// ircServer is the name the gateway uses as the source of its replies and
+// as the host of every client.
+const ircServer = "chatd"
+
+// ircAll is the channel every IRC client is in. Broadcast messages are
+// delivered to it and the clients that are online are its members.
+const ircAll = "&all"
+
+// ircMaxLine is the longest line accepted from a client. The IRC protocol
+// limits lines to 512 bytes but many clients send longer ones.
+const ircMaxLine = 8 * 1024
+
+// Numeric replies used by the gateway.
+const (
+	rplWelcome        = "001"
+	rplYourHost       = "002"
+	rplCreated        = "003"
+	rplMyInfo         = "004"
+	rplEndOfWho       = "315"
+	rplWhoReply       = "352"
+	rplNamReply       = "353"
+	rplEndOfNames     = "366"
+	errNoSuchNick     = "401"
+	errNoSuchChannel  = "403"
+	errNoRecipient    = "411"
+	errNoTextToSend   = "412"
+	errUnknownCommand = "421"
+	errNoMOTD         = "422"
+	errNoNicknameGvn  = "431"
+	errErroneousNick  = "432"
+	errNicknameInUse  = "433"
+	errNotRegistered  = "451"
+	errNeedMoreParams = "461"
+	errAlreadyReg     = "462"
+)
+
+// ircConn represents a single IRC connection.
+type ircConn struct {
+	conn    net.Conn
+	tcpAddr *net.TCPAddr
+
+	mu         sync.Mutex
+	nick       string
+	user       bool
+	registered bool
+}
+
+// write sends the lines to the client.
+func (c *ircConn) write(lines ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write([]byte(b.String()))
+	return err
+}
+
+// reply sends a numeric reply to the client.
+func (c *ircConn) reply(code string, params ...string) error {
+	return c.write(ircLine(ircServer, code, append([]string{c.name()}, params...)...))
+}
+
+// name returns the nick of the client, * until it picked one.
+func (c *ircConn) name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.nick == "" {
+		return "*"
+	}
+	return c.nick
+}
+
+// ircLine formats a line with the specified source. The last parameter is
+// sent as a trailing parameter when it needs to be, to hold spaces.
+func ircLine(source, command string, params ...string) string {
+	var b strings.Builder
+	if source != "" {
+		b.WriteString(":")
+		b.WriteString(source)
+		b.WriteString(" ")
+	}
+	b.WriteString(command)
+
+	for i, p := range params {
+		b.WriteString(" ")
+		if i == len(params)-1 && (p == "" || p[0] == ':' || strings.Contains(p, " ")) {
+			b.WriteString(":")
+		}
+		b.WriteString(p)
+	}
+
+	return b.String()
+}
+
+// ircSource returns the source used for the messages of a chat client.
+func ircSource(id string) string {
+	return id + "!" + id + "@" + ircServer
+}
+
+// ircParse splits a line into its command and parameters.
+func ircParse(line string) (string, []string) {
+
+	// The source of messages sent by clients is ignored.
+	if strings.HasPrefix(line, ":") {
+		if i := strings.Index(line, " "); i >= 0 {
+			line = line[i+1:]
+		} else {
+			line = ""
+		}
+	}
+
+	var trailing *string
+	if i := strings.Index(line, " :"); i >= 0 {
+		t := line[i+2:]
+		trailing = &t
+		line = line[:i]
+	}
+
+	params := strings.Fields(line)
+	if trailing != nil {
+		params = append(params, *trailing)
+	}
+	if len(params) == 0 {
+		return "", nil
+	}
+
+	return strings.ToUpper(params[0]), params[1:]
+}
+
+// validNick reports whether the nick can be used as a chat client id.
+func validNick(nick string) bool {
+	if nick == "" || len(nick) > 10 {
+		return false
+	}
+	return !strings.ContainsAny(nick, " ,*?!@:#&")
+}
+
+// =============================================================================
+
+// IRC accepts clients speaking a subset of the IRC protocol: NICK, USER,
+// JOIN, PART, PRIVMSG, WHO, PING, PONG and QUIT. Channels map to rooms and
+// the &all channel to broadcast messages, and the messages go through the
+// same Process and NATS pipeline as the TCP clients.
+//
+// Register it with the binary codec, Send translates the messages to the
+// IRC protocol for the nick of the connection.
+type IRC struct {
+	CC   cache.SessionStore
+	NATS *NATS
+	HB   *Heartbeat
+	Name string // Name the addresses of the clients are tagged with.
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[string]*ircConn
+}
+
+// NewIRC returns an IRC value ready to be started.
+func NewIRC(cc cache.SessionStore) *IRC {
+	return &IRC{
+		CC:    cc,
+		Name:  "irc",
+		conns: make(map[string]*ircConn),
+	}
+}
+
+// Start binds to the host and accepts clients in the background.
+func (irc *IRC) Start(host string) error {
+	l, err := net.Listen("tcp4", host)
+	if err != nil {
+		return errors.Wrap(err, "irc listen")
+	}
+	irc.listener = l
+
+	irc.wg.Add(1)
+	go func() {
+		defer irc.wg.Done()
+
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Printf("irc : accept : %s\n", err)
+				return
+			}
+
+			irc.wg.Add(1)
+			go func() {
+				defer irc.wg.Done()
+				irc.serve(conn)
+			}()
+		}
+	}()
+
+	log.Printf("irc : service started : Host[ %s ]\n", l.Addr())
+	return nil
+}
+
+// Addr returns the address the clients connect to.
+func (irc *IRC) Addr() net.Addr {
+	return irc.listener.Addr()
+}
+
+// Stop closes the listener and every connection.
+func (irc *IRC) Stop() {
+	if irc.listener == nil {
+		return
+	}
+	irc.listener.Close()
+
+	irc.mu.Lock()
+	for _, c := range irc.conns {
+		c.conn.Close()
+	}
+	irc.mu.Unlock()
+
+	irc.wg.Wait()
+	log.Println("irc : service stoped")
+}
+
+// serve reads the commands of a client until the connection is closed.
+func (irc *IRC) serve(conn net.Conn) {
+	remote, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		conn.Close()
+		return
+	}
+	tcpAddr := listenerAddr(irc.Name, remote)
+	ipAddress := tcpAddr.String()
+
+	c := ircConn{
+		conn:    conn,
+		tcpAddr: tcpAddr,
+	}
+
+	irc.mu.Lock()
+	irc.conns[remote.String()] = &c
+	irc.mu.Unlock()
+	irc.HB.Track(tcpAddr)
+
+	log.Printf("irc : IP[ %s ] : connected\n", ipAddress)
+
+	defer func() {
+		irc.mu.Lock()
+		delete(irc.conns, remote.String())
+		irc.mu.Unlock()
+
+		conn.Close()
+		Leave(irc.CC, irc.NATS, irc.HB, ipAddress)
+		log.Printf("irc : IP[ %s ] : disconnected\n", ipAddress)
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 512), ircMaxLine)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		log.Printf("irc : IP[ %s ] : Inbound : %s\n", ipAddress, line)
+		irc.HB.Seen(ipAddress)
+
+		cmd, params := ircParse(line)
+		if cmd == "QUIT" {
+			return
+		}
+
+		if err := irc.command(&c, cmd, params); err != nil {
+			log.Printf("irc : IP[ %s ] : ERROR : %s\n", ipAddress, err)
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Printf("irc : IP[ %s ] : read : %s\n", ipAddress, err)
+	}
+}
+
+// command executes a command sent by the client.
+func (irc *IRC) command(c *ircConn, cmd string, params []string) error {
+	switch cmd {
+	case "CAP", "PASS":
+		return nil
+
+	case "PING":
+		token := ircServer
+		if len(params) > 0 {
+			token = params[len(params)-1]
+		}
+		return c.write(ircLine(ircServer, "PONG", ircServer, token))
+
+	case "NICK":
+		return irc.nick(c, params)
+
+	case "USER":
+		if c.registered {
+			return c.reply(errAlreadyReg, "You may not reregister")
+		}
+		if len(params) < 4 {
+			return c.reply(errNeedMoreParams, cmd, "Not enough parameters")
+		}
+		c.mu.Lock()
+		c.user = true
+		c.mu.Unlock()
+		return irc.register(c)
+	}
+
+	// Everything else requires a registered client.
+	if !c.registered {
+		return c.reply(errNotRegistered, "You have not registered")
+	}
+
+	switch cmd {
+	case "PONG":
+		irc.process(c, msg.MSG{Type: msg.Pong})
+		return nil
+
+	case "JOIN":
+		if len(params) < 1 {
+			return c.reply(errNeedMoreParams, cmd, "Not enough parameters")
+		}
+		for _, channel := range strings.Split(params[0], ",") {
+			if err := irc.join(c, channel); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "PART":
+		if len(params) < 1 {
+			return c.reply(errNeedMoreParams, cmd, "Not enough parameters")
+		}
+		for _, channel := range strings.Split(params[0], ",") {
+			if err := irc.part(c, channel); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "PRIVMSG":
+		if len(params) < 1 {
+			return c.reply(errNoRecipient, "No recipient given (PRIVMSG)")
+		}
+		if len(params) < 2 || params[1] == "" {
+			return c.reply(errNoTextToSend, "No text to send")
+		}
+		for _, target := range strings.Split(params[0], ",") {
+			if err := irc.privmsg(c, target, params[1]); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "WHO":
+		mask := ircAll
+		if len(params) > 0 {
+			mask = params[0]
+		}
+		return irc.who(c, mask)
+	}
+
+	return c.reply(errUnknownCommand, cmd, "Unknown command")
+}
+
+// nick sets the nick of the client. Changing it once registered is not
+// supported since it is the id of the chat client.
+func (irc *IRC) nick(c *ircConn, params []string) error {
+	if len(params) < 1 {
+		return c.reply(errNoNicknameGvn, "No nickname given")
+	}
+	nick := params[0]
+
+	if c.registered {
+		return c.reply(errErroneousNick, nick, "Nick changes are not supported")
+	}
+	if !validNick(nick) {
+		return c.reply(errErroneousNick, nick, "Erroneous nickname")
+	}
+
+	// Let the client pick another nick rather than dropping it.
+	if _, err := irc.CC.GetID(nick); err == nil || irc.NATS.Config.Bots.Has(nick) {
+		return c.reply(errNicknameInUse, nick, "Nickname is already in use")
+	}
+
+	c.mu.Lock()
+	c.nick = nick
+	c.mu.Unlock()
+
+	return irc.register(c)
+}
+
+// register logs the client in once it sent both NICK and USER.
+func (irc *IRC) register(c *ircConn) error {
+	c.mu.Lock()
+	ready := c.nick != "" && c.user && !c.registered
+	c.registered = c.registered || ready
+	nick := c.nick
+	c.mu.Unlock()
+
+	if !ready {
+		return nil
+	}
+
+	irc.process(c, msg.MSG{Type: msg.Init})
+
+	err := c.write(
+		ircLine(ircServer, rplWelcome, nick, "Welcome to chatd "+nick),
+		ircLine(ircServer, rplYourHost, nick, "Your host is "+ircServer),
+		ircLine(ircServer, rplCreated, nick, "This server speaks a subset of IRC"),
+		ircLine(ircServer, rplMyInfo, nick, ircServer, "chatd"),
+		ircLine(ircServer, errNoMOTD, nick, "MOTD File is missing"),
+	)
+	if err != nil {
+		return err
+	}
+
+	return irc.joined(c, ircAll, irc.online())
+}
+
+// joined tells the client it joined the channel and who is in it.
+func (irc *IRC) joined(c *ircConn, channel string, names []string) error {
+	sort.Strings(names)
+
+	return c.write(
+		ircLine(ircSource(c.nick), "JOIN", channel),
+		ircLine(ircServer, rplNamReply, c.nick, "=", channel, strings.Join(names, " ")),
+		ircLine(ircServer, rplEndOfNames, c.nick, channel, "End of /NAMES list"),
+	)
+}
+
+// join adds the client to the room.
+func (irc *IRC) join(c *ircConn, channel string) error {
+	if channel == ircAll {
+		return nil
+	}
+	if !msg.IsRoom(channel) || len(channel) > 10 {
+		return c.reply(errNoSuchChannel, channel, "No such channel")
+	}
+
+	irc.process(c, msg.MSG{Recipient: channel, Type: msg.JoinRoom})
+	return irc.joined(c, channel, irc.NATS.Config.Rooms.Members(channel))
+}
+
+// part removes the client from the room.
+func (irc *IRC) part(c *ircConn, channel string) error {
+	if !msg.IsRoom(channel) || len(channel) > 10 {
+		return c.reply(errNoSuchChannel, channel, "No such channel")
+	}
+
+	irc.process(c, msg.MSG{Recipient: channel, Type: msg.LeaveRoom})
+	return c.write(ircLine(ircSource(c.nick), "PART", channel))
+}
+
+// privmsg sends the text to everyone, a room or a client.
+func (irc *IRC) privmsg(c *ircConn, target string, text string) error {
+	m := msg.MSG{
+		Type: msg.Message,
+		Data: text,
+	}
+
+	switch {
+	case target == ircAll:
+	case msg.IsRoom(target):
+		if len(target) > 10 {
+			return c.reply(errNoSuchChannel, target, "No such channel")
+		}
+		m.Recipient = target
+	default:
+		if !validNick(target) {
+			return c.reply(errNoSuchNick, target, "No such nick/channel")
+		}
+		m.Recipient = target
+	}
+
+	irc.process(c, m)
+	return nil
+}
+
+// who lists the clients in the channel or the client with the nick.
+func (irc *IRC) who(c *ircConn, mask string) error {
+	var ids []string
+	switch {
+	case mask == ircAll:
+		ids = irc.online()
+	case msg.IsRoom(mask):
+		ids = irc.NATS.Config.Rooms.Members(mask)
+	default:
+		if _, err := irc.CC.GetID(mask); err == nil || irc.NATS.Config.Bots.Has(mask) {
+			ids = append(ids, mask)
+		}
+	}
+	sort.Strings(ids)
+
+	lines := make([]string, 0, len(ids)+1)
+	for _, id := range ids {
+		lines = append(lines, ircLine(ircServer, rplWhoReply, c.nick, mask, id, ircServer, ircServer, id, "H", "0 "+id))
+	}
+	lines = append(lines, ircLine(ircServer, rplEndOfWho, c.nick, mask, "End of WHO list"))
+
+	return c.write(lines...)
+}
+
+// online returns the clients and the bots that are online, the members of
+// the broadcast channel.
+func (irc *IRC) online() []string {
+	names := irc.NATS.Config.Bots.Names()
+	for _, client := range irc.CC.All() {
+		names = append(names, client.ID)
+	}
+	return names
+}
+
+// process sends the message from the client through the pipeline.
+func (irc *IRC) process(c *ircConn, m msg.MSG) {
+	m.Sender = c.nick
+
+	// Everything past the listener works with the binary protocol.
+	d := msg.Encode(m)
+	req := tcp.Request{
+		TCPAddr: c.tcpAddr,
+		ReadAt:  time.Now().UTC(),
+		Context: context.Background(),
+		Data:    d,
+		Length:  len(d),
+	}
+
+	Process(irc.CC, irc.NATS, irc.HB, &req)
+}
+
+// Send implements the Conns interface. It translates the binary message
+// to the IRC protocol and writes it to the connection of the client.
+func (irc *IRC) Send(ctx context.Context, r *tcp.Response) error {
+	c, err := irc.conn(r.TCPAddr)
+	if err != nil {
+		return err
+	}
+
+	m, err := msg.Decode(r.Data)
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	switch m.Type {
+	case msg.Ping:
+		lines = append(lines, ircLine("", "PING", ircServer))
+
+	case msg.InCache:
+		c.reply(errNicknameInUse, m.Recipient, "Nickname is already in use")
+		return c.conn.Close()
+
+	case msg.Join:
+		// The clients of this node were named when joining the channel.
+		if _, err := irc.CC.GetID(m.Sender); m.Recipient != "" && err == nil {
+			return nil
+		}
+		lines = append(lines, ircLine(ircSource(m.Sender), "JOIN", ircAll))
+
+	case msg.Leave:
+		lines = append(lines, ircLine(ircSource(m.Sender), "QUIT", "offline"))
+
+	case msg.JoinRoom:
+		lines = append(lines, ircLine(ircSource(m.Sender), "JOIN", m.Recipient))
+
+	case msg.LeaveRoom:
+		lines = append(lines, ircLine(ircSource(m.Sender), "PART", m.Recipient))
+
+	case msg.Message:
+		target := m.Recipient
+		if target == "" {
+			target = ircAll
+		}
+
+		// Lines can't hold line breaks, send one message per line.
+		for _, text := range strings.Split(strings.ReplaceAll(m.Data, "\r", ""), "\n") {
+			if text == "" {
+				continue
+			}
+			lines = append(lines, ircLine(ircSource(m.Sender), "PRIVMSG", target, text))
+		}
+
+	default:
+		return nil
+	}
+
+	log.Printf("irc : IP[ %s ] : write : %v\n", r.TCPAddr, m)
+	return c.write(lines...)
+}
+
+// Drop implements the Conns interface. It closes the connection of the
+// client, which then leaves.
+func (irc *IRC) Drop(tcpAddr *net.TCPAddr) error {
+	c, err := irc.conn(tcpAddr)
+	if err != nil {
+		return err
+	}
+
+	return c.conn.Close()
+}
+
+// conn finds the connection for the specified address.
+func (irc *IRC) conn(tcpAddr *net.TCPAddr) (*ircConn, error) {
+	irc.mu.Lock()
+	defer irc.mu.Unlock()
+
+	c, exists := irc.conns[tcpAddr.String()]
+	if !exists {
+		return nil, fmt.Errorf("IP[ %s ] : disconnected", tcpAddr)
+	}
+
+	return c, nil
+}
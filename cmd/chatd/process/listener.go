@@ -0,0 +1,141 @@
+package process
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	"chat/pkg/msg"
+
+	"github.com/ardanlabs/kit/tcp"
+	"github.com/pkg/errors"
+)
+
+// Conns defines the behavior required to reach the clients connected to
+// a listener. It is implemented by tcp.TCP.
+type Conns interface {
+	Send(ctx context.Context, r *tcp.Response) error
+	Drop(tcpAddr *net.TCPAddr) error
+}
+
+// Listener represents the client connections that speak the same codec.
+type Listener struct {
+	Name  string // Name the addresses of the clients are tagged with.
+	Conns Conns
+	Codec msg.Codec
+}
+
+// Listeners represents every listener chatd accepts clients on.
+type Listeners []Listener
+
+// Send delivers the message to the client, encoded with the codec of the
+// listener the client is connected to.
+func (ls Listeners) Send(tcpAddr *net.TCPAddr, m msg.MSG) error {
+	err := errors.Errorf("IP[ %s ] : disconnected", tcpAddr)
+
+	name, remote := splitAddr(tcpAddr)
+	for _, l := range ls {
+		if l.Name != name {
+			continue
+		}
+
+		d := codec(l.Codec).Encode(m)
+		resp := tcp.Response{
+			TCPAddr: remote,
+			Data:    d,
+			Length:  len(d),
+		}
+
+		if err = l.Conns.Send(context.TODO(), &resp); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// Drop closes the client connection on the listener it is connected to.
+func (ls Listeners) Drop(tcpAddr *net.TCPAddr) error {
+	err := errors.Errorf("IP[ %s ] : disconnected", tcpAddr)
+
+	name, remote := splitAddr(tcpAddr)
+	for _, l := range ls {
+		if l.Name != name {
+			continue
+		}
+
+		if err = l.Conns.Drop(remote); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// =============================================================================
+
+// The remote address of a client is only unique on its listener, clients
+// connected to different listeners can share one. Past the listeners the
+// address of a client is tagged with the name of its listener in the zone,
+// so the sessions, the heartbeat and the deliveries are keyed by both.
+
+// listenerAddr returns the address of the client connected to the named
+// listener from the specified remote address.
+func listenerAddr(name string, tcpAddr *net.TCPAddr) *net.TCPAddr {
+	zone := name
+	if tcpAddr.Zone != "" {
+		zone += "/" + tcpAddr.Zone
+	}
+
+	return &net.TCPAddr{
+		IP:   tcpAddr.IP,
+		Port: tcpAddr.Port,
+		Zone: zone,
+	}
+}
+
+// splitAddr returns the name of the listener and the remote address of
+// the client from an address returned by listenerAddr.
+func splitAddr(tcpAddr *net.TCPAddr) (string, *net.TCPAddr) {
+	name, zone, _ := strings.Cut(tcpAddr.Zone, "/")
+
+	return name, &net.TCPAddr{
+		IP:   tcpAddr.IP,
+		Port: tcpAddr.Port,
+		Zone: zone,
+	}
+}
+
+// parseAddr parses the string form of an address returned by listenerAddr.
+func parseAddr(s string) (*net.TCPAddr, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "address[ %s ]", s)
+	}
+
+	var zone string
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		host, zone = host[:i], host[i+1:]
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, errors.Errorf("address[ %s ] : invalid IP", s)
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, errors.Wrapf(err, "address[ %s ]", s)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: p, Zone: zone}, nil
+}
+
+// codec returns the codec to use, the binary protocol by default.
+func codec(c msg.Codec) msg.Codec {
+	if c == nil {
+		return msg.Binary{}
+	}
+	return c
+}
@@ -0,0 +1,80 @@
+//go:build linux || darwin
+
+package process_test
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+
+	"chat/internal/platform/bus"
+	"chat/pkg/msg"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestSharedAddress test that clients connected to different listeners
+// from the same address are told apart.
+func TestSharedAddress(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+
+	// Both connections are bound to the same local address, which only
+	// the reuse option allows.
+	d := net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			c.Control(func(fd uintptr) {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+			})
+			return err
+		},
+	}
+
+	conn, err := d.DialContext(context.Background(), "tcp4", n.Addr())
+	if err != nil {
+		t.Fatalf("Should be able to connect : %v", err)
+	}
+	bill := login(t, n, conn, msg.Binary{}, "bill", false)
+
+	d.LocalAddr = conn.LocalAddr()
+	if conn, err = d.DialContext(context.Background(), "tcp4", n.JSONAddr()); err != nil {
+		t.Fatalf("Should be able to connect from the same address : %v", err)
+	}
+	jill := login(t, n, conn, msg.JSON{}, "jill", false)
+	bill.Expect(msg.Join)
+
+	t.Log("Given the need to tell apart clients of different listeners sharing an address.")
+	{
+		t.Logf("\tTest 0:\tWhen clients share an address")
+		{
+			bill.Send(msg.MSG{Recipient: "jill", Type: msg.Message, Data: "hello"})
+			if m := jill.Expect(msg.Message); m.Sender != "bill" {
+				t.Fatalf("\t%s\tShould deliver to the JSON client : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould deliver to the JSON client.\n", succeed)
+
+			jill.Send(msg.MSG{Recipient: "bill", Type: msg.Message, Data: "hi"})
+			if m := bill.Expect(msg.Message); m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould deliver to the binary client : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould deliver to the binary client.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen one of the clients leaves")
+		{
+			jill.Close()
+			if m := bill.Expect(msg.Leave); m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould be told jill left : got%v\n", failed, m)
+			}
+			if _, err := n.CC.GetID("bill"); err != nil {
+				t.Fatalf("\t%s\tShould keep the other client : %v\n", failed, err)
+			}
+			t.Logf("\t%s\tShould keep the other client.\n", succeed)
+		}
+	}
+}
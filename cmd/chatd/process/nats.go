@@ -11,43 +11,104 @@ gnatsd
 */
 
 import (
-	"context"
+	"encoding/base64"
+	"hash/fnv"
 	"log"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"chat/internal/msg"
+	"chat/internal/platform/bus"
 	"chat/internal/platform/cache"
+	"chat/internal/platform/history"
+	"chat/internal/platform/webhook"
+	"chat/pkg/msg"
 
-	"github.com/ardanlabs/kit/tcp"
 	nats "github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
 )
 
 // natsProcess handles the messages that are consumed from nats.
-func natsProcess(cc *cache.Cache, nts *NATS, t *tcp.TCP, nm *nats.Msg) {
-	switch nm.Subject {
-	case natsSubject:
+func natsProcess(cc cache.SessionStore, nts *NATS, ls Listeners, nm *bus.Msg) {
+	switch {
+	case nm.Subject == natsSubject, nm.Subject == nts.nodeSubject(),
+		strings.HasPrefix(nm.Subject, natsUserPrefix), strings.HasPrefix(nm.Subject, natsRoomPrefix):
 
 		// Decode the message received.
-		id, m := natsDecode(nm.Data)
+		id, m, err := natsDecode(nm.Data)
+		if err != nil {
+			log.Printf("Nats_Process : IP[ nats ] : ERROR : Inbound : %s\n", err)
+			return
+		}
 		log.Printf("Nats_Process : IP[ nats ] : Inbound : ID[ %s ]%v\n", id, m)
 
-		d := msg.Encode(m)
-
 		if m.Type == msg.InCache {
-			tcpValues := strings.Split(m.Data, ":")
-			ipv4 := net.ParseIP(tcpValues[0])
-			port, err := strconv.Atoi(tcpValues[1])
+			tcpAddr, err := parseAddr(m.Data)
 			if err != nil {
-				log.Printf("Nats_Process : IP[ %s ] : ERROR : Port : %s\n", ipv4, err)
+				log.Printf("Nats_Process : IP[ nats ] : ERROR : InCache : %s\n", err)
+				return
+			}
+			log.Printf("Nats_Process : IP[ %s ] : InCache : Client [ %s ] already in cache\n", tcpAddr, m.Recipient)
+
+			forwardTCPResponse(tcpAddr, m, ls)
+			return
+		}
+
+		// Every node tells a client that came online who is connected to it.
+		if m.Type == msg.Join && m.Recipient == "" {
+			nts.roster(m.Sender)
+		}
+
+		// The node of the client sending a file holds the transfer to the
+		// answer. Accepting a transfer over the quota rejects it to both
+		// clients instead, accepting an unknown one is refused.
+		switch m.Type {
+		case msg.FileAccept, msg.FileReject:
+			if err := nts.Config.Files.Answer(m); err != nil {
+				log.Printf("Nats_Process : IP[ nats ] : ERROR : file : %s\n", err)
+
+				id, _ := msg.ParseFileReply(m.Data)
+				reject := msg.MSG{
+					Sender:    m.Recipient,
+					Recipient: m.Sender,
+					Type:      msg.FileReject,
+					Data:      msg.FileReply(id, err.Error()),
+				}
+				if err := nts.SendMsg(reject); err != nil {
+					log.Printf("Nats_Process : IP[ nats ] : ERROR : file : %s\n", err)
+				}
+
+				if err == errUnknownTransfer {
+					return
+				}
+				m = msg.MSG{
+					Sender:    m.Sender,
+					Recipient: m.Recipient,
+					Type:      msg.FileReject,
+					Data:      msg.FileReply(id, err.Error()),
+				}
 			}
-			log.Printf("Nats_Process : IP[ %s ] Port [%d] : InCache : Client [ %s ] already in cache\n", ipv4, port, m.Recipient)
+		}
 
-			forwardTCPResponse(ipv4, port, d, t)
+		// Room messages go to the members of the room connected to this node.
+		if msg.IsRoom(m.Recipient) {
+			for _, id := range nts.Config.Rooms.Members(m.Recipient) {
+				if id == m.Sender {
+					continue
+				}
+
+				client, err := cc.GetID(id)
+				if err != nil {
+					log.Printf("Nats_Process : IP[ nats ] : ERROR : Room[ %s ] : %s\n", m.Recipient, err)
+					continue
+				}
+
+				log.Printf("Nats_Process : IP[ %s ] : Send : Room[ %s ] : client[ %s ]\n", client.TCPAddr.IP, m.Recipient, client.ID)
+				forwardTCPResponse(client.TCPAddr, m, ls)
+			}
 			return
 		}
 
@@ -60,26 +121,21 @@ func natsProcess(cc *cache.Cache, nts *NATS, t *tcp.TCP, nm *nats.Msg) {
 			}
 
 			log.Printf("Nats_Process : IP[ %s ] : Send : client[ %s ]\n", ipAddress, client.ID)
-			forwardTCPResponse(client.TCPAddr.IP, client.TCPAddr.Port, d, t)
+			forwardTCPResponse(client.TCPAddr, m, ls)
 		}
 
+	case nm.Subject == natsHistory:
+		nts.apply(nm.Data)
+
 	default:
 		log.Printf("Nats_Process : IP[ nats ] : Inbound : Unknown Subject[ %s ]\n", nm.Subject)
 	}
 }
 
 // Prepares and sends a TCP response.
-func forwardTCPResponse(ipv4 net.IP, port int, d []byte, t *tcp.TCP) {
-	resp := tcp.Response{
-		TCPAddr: &net.TCPAddr{
-			IP:   ipv4,
-			Port: port,
-		},
-		Data:   d,
-		Length: len(d),
-	}
-	if err := t.Send(context.TODO(), &resp); err != nil {
-		log.Printf("Socket_Process : IP[ %s ] : ERROR : Send : %s\n", ipv4, err)
+func forwardTCPResponse(tcpAddr *net.TCPAddr, m msg.MSG, ls Listeners) {
+	if err := ls.Send(tcpAddr, m); err != nil {
+		log.Printf("Socket_Process : IP[ %s ] : ERROR : Send : %s\n", tcpAddr, err)
 	}
 }
 
@@ -87,68 +143,111 @@ func forwardTCPResponse(ipv4 net.IP, port int, d []byte, t *tcp.TCP) {
 
 // Nats subjects.
 const (
-	natsSubject = "msg" // Handling based communication.
+	natsSubject    = "msg"         // Handling based communication.
+	natsNodePrefix = "msg.node."   // Messages for the clients of a single node.
+	natsUserPrefix = "msg.user."   // Direct messages for a single client.
+	natsRoomPrefix = "msg.room."   // Room messages for a single partition when sharded.
+	natsHistory    = "msg.history" // Changes to the history, applied by every node.
 )
 
+// userSubject returns the subject direct messages for the specified client
+// are published on. The id is encoded since it can hold characters that are
+// not valid in a subject.
+func userSubject(id string) string {
+	return natsUserPrefix + base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+// roomSubject returns the subject the messages for the specified room are
+// published on. Rooms are hashed onto one of the partitions when sharding
+// is enabled, otherwise they are broadcast.
+func (nts *NATS) roomSubject(room string) string {
+	if nts.Config.Partitions <= 0 {
+		return natsSubject
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(room))
+	return natsRoomPrefix + strconv.Itoa(int(h.Sum32()%uint32(nts.Config.Partitions)))
+}
+
+// nodeSubject returns the subject messages for the clients of this node are
+// published on.
+func (nts *NATS) nodeSubject() string {
+	return natsNodePrefix + nts.id
+}
+
 // NATSConfig represents required configuration for the nats system.
 type NATSConfig struct {
-	Host string
-	CC   *cache.Cache
-	TCP  *tcp.TCP
+	Host       string
+	Bus        bus.Bus // Optional, a nats connection to Host is used when nil.
+	Partitions int     // Number of room partitions, zero broadcasts room messages.
+	CC         cache.SessionStore
+	Rooms      *cache.Rooms
+	Listeners  Listeners
+	Webhooks   *webhook.Dispatcher // Optional, told about the messages sent from this node.
+	Bots       *Bots               // Optional, answer the commands sent from this node.
+	Files      *Files              // Optional, limits the files sent from this node.
+	History    *history.Store      // Optional, stores the messages of every node, kept in sync over NATS.
+	Operators  []string            // Clients allowed to edit and delete any message.
 }
 
 // NATS represents a nats system from message handling.
 type NATS struct {
 	Config NATSConfig
 
-	id   string
-	conn *nats.Conn
-	subs map[string]*nats.Subscription
+	id      string
+	bus     bus.Bus
+	ownBus  bool
+	handler bus.Handler
+	typing  typing
+
+	mu   sync.Mutex
+	subs map[string]bus.Subscription
 }
 
 // StartNATS initializes access to a nats system.
 func StartNATS(cfg NATSConfig) (*NATS, error) {
 
-	// Set nats options for connection.
-	opts := nats.Options{
-		Url:            cfg.Host,
-		AllowReconnect: true,
-		MaxReconnect:   -1,
-		ReconnectWait:  time.Second,
-		Timeout:        5 * time.Second,
-	}
-
-	// Connect to the specified nats server.
-	conn, err := opts.Connect()
-	if err != nil {
-		return nil, errors.Wrap(err, "connecting to NATS")
-	}
-
 	// Construct the nats value.
 	nts := NATS{
 		Config: cfg,
 		id:     uuid.NewV1().String(),
-		conn:   conn,
-		subs:   make(map[string]*nats.Subscription),
+		bus:    cfg.Bus,
+		subs:   make(map[string]bus.Subscription),
+		typing: typing{last: make(map[string]time.Time)},
 	}
 
-	// Declare the event handler for handling recieved messages.
-	f := func(msg *nats.Msg) {
-		natsProcess(cfg.CC, &nts, cfg.TCP, msg)
-	}
+	// Connect to the specified nats server when no bus was provided.
+	if nts.bus == nil {
 
-	// Register the event handler for each known subject.
-	for _, subject := range []string{natsSubject} {
+		// Set nats options for connection.
+		opts := nats.Options{
+			Url:            cfg.Host,
+			AllowReconnect: true,
+			MaxReconnect:   -1,
+			ReconnectWait:  time.Second,
+			Timeout:        5 * time.Second,
+		}
 
-		// Subscribe to receive messages for the specified subject.
-		sub, err := conn.Subscribe(subject, f)
+		conn, err := opts.Connect()
 		if err != nil {
-			return nil, errors.Wrapf(err, "subscribing to subject : %s", subject)
+			return nil, errors.Wrap(err, "connecting to NATS")
 		}
 
-		// Save the subscription with its associated subject.
-		nts.subs[subject] = sub
-		log.Printf("nats : subject subscribed : Subject[ %s ]\n", subject)
+		nts.bus = bus.NewNATS(conn)
+		nts.ownBus = true
+	}
+
+	// Declare the event handler for handling recieved messages.
+	nts.handler = func(msg *bus.Msg) {
+		natsProcess(cfg.CC, &nts, cfg.Listeners, msg)
+	}
+
+	// Register the event handler for each known subject.
+	for _, subject := range []string{natsSubject, nts.nodeSubject(), natsHistory} {
+		if err := nts.subscribe(subject); err != nil {
+			return nil, err
+		}
 	}
 
 	log.Printf("nats : service started : Host[ %s ]\n", cfg.Host)
@@ -162,6 +261,9 @@ func (nts *NATS) Stop() {
 		return
 	}
 
+	nts.mu.Lock()
+	defer nts.mu.Unlock()
+
 	if nts.subs != nil {
 
 		// Go through each subscription and unsubscribe.
@@ -173,15 +275,205 @@ func (nts *NATS) Stop() {
 
 			log.Printf("nats : unsubscribed : subject[ %s ]\n", subject)
 		}
+		nts.subs = make(map[string]bus.Subscription)
+	}
+
+	if nts.ownBus {
+		nts.bus.Close()
 	}
 
 	log.Printf("nats : service stoped : Host[ %s ]\n", nts.Config.Host)
 }
 
-// SendMsg publishes the nats  to other Tea services.
+// JoinUser subscribes to the direct messages for a client connected to
+// this node.
+func (nts *NATS) JoinUser(id string) error {
+	return nts.subscribe(userSubject(id))
+}
+
+// LeaveUser unsubscribes from the direct messages for a client that is no
+// longer connected to this node.
+func (nts *NATS) LeaveUser(id string) error {
+	return nts.unsubscribe(userSubject(id))
+}
+
+// unsubscribe removes the subscription for the specified subject.
+func (nts *NATS) unsubscribe(subject string) error {
+	nts.mu.Lock()
+	defer nts.mu.Unlock()
+
+	return nts.unsubscribeLocked(subject)
+}
+
+// unsubscribeLocked removes the subscription for the specified subject. The
+// caller holds nts.mu.
+func (nts *NATS) unsubscribeLocked(subject string) error {
+	sub, exists := nts.subs[subject]
+	if !exists {
+		return nil
+	}
+	delete(nts.subs, subject)
+
+	if err := sub.Unsubscribe(); err != nil {
+		return errors.Wrapf(err, "unsubscribing from subject : %s", subject)
+	}
+
+	log.Printf("nats : unsubscribed : subject[ %s ]\n", subject)
+	return nil
+}
+
+// JoinRoom adds a client connected to this node to the room and subscribes
+// to the partition the room is hashed onto. The membership and the
+// subscriptions change under nts.mu, so a partition can't be pruned while a
+// room is joined on it.
+func (nts *NATS) JoinRoom(room string, id string) error {
+	nts.mu.Lock()
+	defer nts.mu.Unlock()
+
+	nts.Config.Rooms.Join(room, id)
+
+	if subject := nts.roomSubject(room); subject != natsSubject {
+		return nts.subscribeLocked(subject)
+	}
+	return nil
+}
+
+// LeaveRoom removes a client connected to this node from the room.
+func (nts *NATS) LeaveRoom(room string, id string) error {
+	nts.mu.Lock()
+	defer nts.mu.Unlock()
+
+	nts.Config.Rooms.Leave(room, id)
+	return nts.prune([]string{room})
+}
+
+// LeaveRooms removes a client connected to this node from every room it
+// joined and returns those rooms.
+func (nts *NATS) LeaveRooms(id string) ([]string, error) {
+	nts.mu.Lock()
+	defer nts.mu.Unlock()
+
+	rooms := nts.Config.Rooms.LeaveAll(id)
+	return rooms, nts.prune(rooms)
+}
+
+// prune unsubscribes from the partitions of the specified rooms when no
+// room with members on this node is hashed onto them anymore. The caller
+// holds nts.mu.
+func (nts *NATS) prune(rooms []string) error {
+	if nts.Config.Partitions <= 0 {
+		return nil
+	}
+
+	needed := make(map[string]bool)
+	for _, room := range nts.Config.Rooms.Names() {
+		needed[nts.roomSubject(room)] = true
+	}
+
+	for _, room := range rooms {
+		subject := nts.roomSubject(room)
+		if needed[subject] {
+			continue
+		}
+
+		if err := nts.unsubscribeLocked(subject); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// subscribe registers the event handler for the specified subject.
+func (nts *NATS) subscribe(subject string) error {
+	nts.mu.Lock()
+	defer nts.mu.Unlock()
+
+	return nts.subscribeLocked(subject)
+}
+
+// subscribeLocked registers the event handler for the specified subject.
+// The caller holds nts.mu.
+func (nts *NATS) subscribeLocked(subject string) error {
+	if _, exists := nts.subs[subject]; exists {
+		return nil
+	}
+
+	// Subscribe to receive messages for the specified subject.
+	sub, err := nts.bus.Subscribe(subject, nts.handler)
+	if err != nil {
+		return err
+	}
+
+	// Save the subscription with its associated subject.
+	nts.subs[subject] = sub
+	log.Printf("nats : subject subscribed : Subject[ %s ]\n", subject)
+
+	return nil
+}
+
+// SendMsg publishes the nats  to other Tea services. Direct messages only
+// reach the node the recipient is connected to, in cache notices only reach
+// this node, room messages reach the nodes subscribed to the room partition
+// and everything else is broadcast. Chat messages are given an id and
+// recorded in the history first, they are refused when their id is used.
 func (nts *NATS) SendMsg(m msg.MSG) error {
-	log.Printf("Nats_Process : IP[ nats ] : Outbound : Sending To NATS : %v\n", m)
-	return nts.conn.Publish(natsSubject, nts.natsEncode(m))
+	if m.Type == msg.Message {
+		if err := nts.record(&m); err != nil {
+			return err
+		}
+	}
+
+	subject := natsSubject
+	switch {
+	case m.Type == msg.InCache:
+		subject = nts.nodeSubject()
+	case msg.IsRoom(m.Recipient):
+		subject = nts.roomSubject(m.Recipient)
+	case m.Recipient != "":
+		subject = userSubject(m.Recipient)
+	}
+
+	log.Printf("Nats_Process : IP[ nats ] : Outbound : Sending To NATS : Subject[ %s ]%v\n", subject, m)
+	if err := nts.bus.Publish(subject, nts.natsEncode(m)); err != nil {
+		return err
+	}
+
+	// Only the node the message is sent from notifies the webhooks, in
+	// cache notices are not chat events, file chunks are too noisy and
+	// typing notices are not kept anywhere.
+	switch m.Type {
+	case msg.InCache, msg.FileChunk, msg.Typing:
+	default:
+		nts.Config.Webhooks.Dispatch(webhook.Event{
+			ID:        m.ID,
+			ReplyTo:   m.ReplyTo,
+			Sender:    m.Sender,
+			Recipient: m.Recipient,
+			Type:      msg.TypeName(m.Type),
+			Data:      m.Data,
+			Direct:    m.Recipient != "" && !msg.IsRoom(m.Recipient),
+			Time:      time.Now().UTC(),
+		})
+	}
+
+	return nil
+}
+
+// roster sends the client the joins of the clients connected to this node.
+// They are direct messages, so nobody else is told about them again.
+func (nts *NATS) roster(id string) {
+	for _, client := range nts.Config.CC.Get(id) {
+		m := msg.MSG{
+			Sender:    client.ID,
+			Recipient: id,
+			Type:      msg.Join,
+		}
+		if err := nts.bus.Publish(userSubject(id), nts.natsEncode(m)); err != nil {
+			log.Printf("Nats_Process : IP[ nats ] : ERROR : roster : %s\n", err)
+			return
+		}
+	}
 }
 
 // ledID represents the length of the UUID based string we use for the id.
@@ -205,10 +497,18 @@ func (nts *NATS) natsEncode(m msg.MSG) []byte {
 }
 
 // natsDecode decodes the byte data into a msg.MSG.
-func natsDecode(data []byte) (string, msg.MSG) {
+func natsDecode(data []byte) (string, msg.MSG, error) {
+	if len(data) < lenID {
+		return "", msg.MSG{}, errors.New("nats message shorter than the id")
+	}
 
 	// Decode the part of the data that represents the id.
 	id := string(data[:lenID])
 
-	return id, msg.Decode(data[lenID:])
+	m, err := msg.Decode(data[lenID:])
+	if err != nil {
+		return "", msg.MSG{}, errors.Wrapf(err, "decoding message from [ %s ]", id)
+	}
+
+	return id, m, nil
 }
@@ -0,0 +1,223 @@
+package process_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"chat/cmd/chatd/process"
+	"chat/internal/platform/bus"
+	"chat/internal/platform/cache"
+	"chat/pkg/msg"
+)
+
+const succeed = "\u2713"
+const failed = "\u2717"
+
+// TestSendMsg test that messages are published on the right subjects.
+func TestSendMsg(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	cfg := process.NATSConfig{
+		Bus:        b,
+		Partitions: 4,
+		CC:         cache.New(),
+		Rooms:      cache.NewRooms(),
+	}
+
+	nts, err := process.StartNATS(cfg)
+	if err != nil {
+		t.Fatalf("Should be able to start NATS : %v", err)
+	}
+	defer nts.Stop()
+
+	// Record the subjects every message is published on.
+	var subjects []string
+	record := func(subject string) {
+		b.Subscribe(subject, func(m *bus.Msg) {
+			subjects = append(subjects, m.Subject)
+		})
+	}
+
+	user := "msg.user." + base64.RawURLEncoding.EncodeToString([]byte("jill"))
+	record("msg")
+	record(user)
+	for _, p := range []string{"0", "1", "2", "3"} {
+		record("msg.room." + p)
+	}
+
+	tt := []struct {
+		name    string
+		m       msg.MSG
+		subject string
+	}{
+		{
+			name:    "broadcast",
+			m:       msg.MSG{Sender: "bill", Type: msg.Message, Data: "hello"},
+			subject: "msg",
+		},
+		{
+			name:    "direct",
+			m:       msg.MSG{Sender: "bill", Recipient: "jill", Type: msg.Message, Data: "hello"},
+			subject: user,
+		},
+		{
+			name:    "room",
+			m:       msg.MSG{Sender: "bill", Recipient: "#go", Type: msg.Message, Data: "hello"},
+			subject: "msg.room.",
+		},
+	}
+
+	t.Log("Given the need to test routing messages onto subjects.")
+	{
+		for i, tst := range tt {
+			t.Logf("\tTest %d:\t%s", i, tst.name)
+			{
+				subjects = nil
+				if err := nts.SendMsg(tst.m); err != nil {
+					t.Fatalf("\t%s\tShould be able to send the message : %v\n", failed, err)
+				}
+				t.Logf("\t%s\tShould be able to send the message.\n", succeed)
+
+				if len(subjects) != 1 || subjects[0][:len(tst.subject)] != tst.subject {
+					t.Fatalf("\t%s\tShould be published on one subject : exp[%s] got%v\n", failed, tst.subject, subjects)
+				}
+				t.Logf("\t%s\tShould be published on one subject.\n", succeed)
+			}
+		}
+	}
+}
+
+// countingBus counts the subscriptions made on every subject. Unsubscribing
+// is slow, like it is over the network.
+type countingBus struct {
+	*bus.Memory
+
+	mu   sync.Mutex
+	subs map[string]int
+}
+
+func (b *countingBus) Subscribe(subject string, h bus.Handler) (bus.Subscription, error) {
+	sub, err := b.Memory.Subscribe(subject, h)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	b.subs[subject]++
+	b.mu.Unlock()
+
+	return countingSub{Subscription: sub, bus: b, subject: subject}, nil
+}
+
+func (b *countingBus) count(subject string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.subs[subject]
+}
+
+// countingSub takes its subscription off the count when it unsubscribes.
+type countingSub struct {
+	bus.Subscription
+	bus     *countingBus
+	subject string
+}
+
+func (s countingSub) Unsubscribe() error {
+	time.Sleep(5 * time.Millisecond)
+
+	s.bus.mu.Lock()
+	s.bus.subs[s.subject]--
+	s.bus.mu.Unlock()
+
+	return s.Subscription.Unsubscribe()
+}
+
+// TestRoomPartitions test that the node stays subscribed to the partition of
+// a room joined while other rooms are left.
+func TestRoomPartitions(t *testing.T) {
+	b := countingBus{Memory: bus.NewMemory(), subs: make(map[string]int)}
+	defer b.Close()
+
+	partitions := []string{"0", "1", "2"}
+	cfg := process.NATSConfig{
+		Bus:        &b,
+		Partitions: len(partitions),
+		CC:         cache.New(),
+		Rooms:      cache.NewRooms(),
+	}
+
+	nts, err := process.StartNATS(cfg)
+	if err != nil {
+		t.Fatalf("Should be able to start NATS : %v", err)
+	}
+	defer nts.Stop()
+
+	// Find two rooms on each partition.
+	rooms := make(map[string][]string)
+	found := func() bool {
+		for _, p := range partitions {
+			if len(rooms[p]) < 2 {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; !found(); i++ {
+		room := fmt.Sprintf("#r%d", i)
+		nts.JoinRoom(room, "probe")
+		for _, p := range partitions {
+			if b.count("msg.room."+p) == 1 {
+				rooms[p] = append(rooms[p], room)
+			}
+		}
+		nts.LeaveRoom(room, "probe")
+	}
+
+	t.Log("Given the need to subscribe to the partitions of the rooms.")
+	{
+		t.Logf("\tTest 0:\tJoin a room while others are left")
+		{
+			for i := 0; i < 10; i++ {
+				for _, p := range partitions {
+					nts.JoinRoom(rooms[p][0], "bill")
+				}
+
+				// A client joins a room on every partition while bill is
+				// leaving them.
+				var wg sync.WaitGroup
+				wg.Add(1 + len(partitions))
+				go func() {
+					defer wg.Done()
+					nts.LeaveRooms("bill")
+				}()
+				for _, p := range partitions {
+					go func(room string) {
+						defer wg.Done()
+						time.Sleep(time.Millisecond)
+						nts.JoinRoom(room, "jill")
+					}(rooms[p][1])
+				}
+				wg.Wait()
+
+				for _, p := range partitions {
+					if n := b.count("msg.room." + p); n != 1 {
+						t.Fatalf("\t%s\tShould stay subscribed while a room is joined : partition[%s] got[%d]\n", failed, p, n)
+					}
+				}
+				nts.LeaveRooms("jill")
+			}
+			t.Logf("\t%s\tShould stay subscribed while a room is joined.\n", succeed)
+
+			for _, p := range partitions {
+				if n := b.count("msg.room." + p); n != 0 {
+					t.Fatalf("\t%s\tShould unsubscribe once every room is left : partition[%s] got[%d]\n", failed, p, n)
+				}
+			}
+			t.Logf("\t%s\tShould unsubscribe once every room is left.\n", succeed)
+		}
+	}
+}
@@ -0,0 +1,1347 @@
+package process_test
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"chat/cmd/chatd/process"
+	"chat/internal/platform/bus"
+	"chat/internal/platform/history"
+	"chat/internal/platform/webhook"
+	"chat/pkg/msg"
+
+	"github.com/ardanlabs/kit/tcp"
+	"github.com/gorilla/websocket"
+)
+
+// TestDelivery test that broadcast, direct and room messages reach the
+// right clients across nodes sharing a bus.
+func TestDelivery(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		b := bus.NewMemory()
+		defer b.Close()
+
+		testDelivery(t, startNode(t, b, "", 0), startNode(t, b, "", 0))
+	})
+
+	t.Run("nats", func(t *testing.T) {
+		host := startEmbedded(t)
+
+		testDelivery(t, startNode(t, nil, host, 0), startNode(t, nil, host, 0))
+	})
+}
+
+// testDelivery runs the delivery scenario against two nodes.
+func testDelivery(t *testing.T, n1 *node, n2 *node) {
+	bill := connect(t, n1, "bill", false)
+	jill := connect(t, n2, "jill", false)
+	cory := connect(t, n2, "cory", false)
+
+	t.Log("Given the need to deliver messages across nodes.")
+	{
+		t.Logf("\tTest 0:\tPresence")
+		{
+			if m := bill.Expect(msg.Join); m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould be told jill joined : got[%s]\n", failed, m.Sender)
+			}
+			t.Logf("\t%s\tShould be told jill joined.\n", succeed)
+
+			online := make(map[string]bool)
+			for i := 0; i < 2; i++ {
+				if m := cory.Expect(msg.Join); m.Recipient == "cory" {
+					online[m.Sender] = true
+				}
+			}
+			if !online["bill"] || !online["jill"] {
+				t.Fatalf("\t%s\tShould be told who was online on every node : got%v\n", failed, online)
+			}
+			cory.ExpectNone(msg.Join)
+			t.Logf("\t%s\tShould be told who was online on every node.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tBroadcast")
+		{
+			bill.Send(msg.MSG{Type: msg.Message, Data: "hello all"})
+
+			for _, c := range []*client{jill, cory} {
+				if m := c.Expect(msg.Message); m.Data != "hello all" || m.Sender != "bill" {
+					t.Fatalf("\t%s\tShould deliver to [ %s ] : got%v\n", failed, c.Name, m)
+				}
+			}
+			t.Logf("\t%s\tShould deliver to everyone else.\n", succeed)
+
+			bill.ExpectNone(msg.Message)
+			t.Logf("\t%s\tShould not deliver back to the sender.\n", succeed)
+		}
+
+		t.Logf("\tTest 2:\tDirect message")
+		{
+			bill.Send(msg.MSG{Recipient: "jill", Type: msg.Message, Data: "hello jill"})
+
+			if m := jill.Expect(msg.Message); m.Data != "hello jill" || m.Recipient != "jill" {
+				t.Fatalf("\t%s\tShould deliver to the recipient : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould deliver to the recipient.\n", succeed)
+
+			cory.ExpectNone(msg.Message)
+			t.Logf("\t%s\tShould not deliver to anyone else.\n", succeed)
+		}
+
+		t.Logf("\tTest 3:\tRoom message")
+		{
+			bill.Send(msg.MSG{Recipient: "#go", Type: msg.JoinRoom})
+			eventually(t, "bill to join the room", func() bool {
+				return len(n1.NATS.Config.Rooms.Members("#go")) == 1
+			})
+
+			jill.Send(msg.MSG{Recipient: "#go", Type: msg.JoinRoom})
+			if m := bill.Expect(msg.JoinRoom); m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould be told jill joined the room : got%v\n", failed, m)
+			}
+
+			jill.Send(msg.MSG{Recipient: "#go", Type: msg.Message, Data: "hello room"})
+
+			if m := bill.Expect(msg.Message); m.Data != "hello room" || m.Recipient != "#go" {
+				t.Fatalf("\t%s\tShould deliver to the room members : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould deliver to the room members.\n", succeed)
+
+			cory.ExpectNone(msg.Message)
+			t.Logf("\t%s\tShould not deliver to anyone else.\n", succeed)
+		}
+	}
+}
+
+// TestDuplicateName test that a name that is already connected is rejected.
+func TestDuplicateName(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+	connect(t, n, "bill", false)
+
+	t.Log("Given the need to reject duplicate names.")
+	{
+		t.Logf("\tTest 0:\tSame name twice")
+		{
+			dup := connect(t, n, "bill", true)
+
+			if m := dup.Expect(msg.InCache); m.Recipient != "bill" {
+				t.Fatalf("\t%s\tShould tell the client the name is taken : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould tell the client the name is taken.\n", succeed)
+
+			if n := len(n.CC.All()); n != 1 {
+				t.Fatalf("\t%s\tShould keep a single client in the cache : got[%d]\n", failed, n)
+			}
+			t.Logf("\t%s\tShould keep a single client in the cache.\n", succeed)
+		}
+	}
+}
+
+// TestServerTypes test that clients can't send the messages only the
+// server sends.
+func TestServerTypes(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+	bill := connect(t, n, "bill", false)
+	jill := connect(t, n, "jill", false)
+
+	t.Log("Given the need to keep presence to the server.")
+	{
+		t.Logf("\tTest 0:\tClient sends server messages")
+		{
+			bill.Send(msg.MSG{Type: msg.Leave})
+			bill.Send(msg.MSG{Type: msg.Join})
+			bill.Send(msg.MSG{Recipient: "jill", Type: msg.InCache, Data: jill.conn.LocalAddr().String()})
+			bill.Send(msg.MSG{Recipient: "jill", Type: msg.Ping})
+			bill.Send(msg.MSG{ID: "m1", Recipient: "jill", Type: msg.Search, Data: "forged"})
+
+			for _, typ := range []uint8{msg.Leave, msg.Join, msg.InCache, msg.Ping, msg.Search} {
+				jill.ExpectNone(typ)
+			}
+			t.Logf("\t%s\tShould not deliver them.\n", succeed)
+
+			if _, err := n.CC.GetID("jill"); err != nil {
+				t.Fatalf("\t%s\tShould keep the other client connected : %v\n", failed, err)
+			}
+			bill.Send(msg.MSG{Recipient: "jill", Type: msg.Message, Data: "still here"})
+			if m := jill.Expect(msg.Message); m.Data != "still here" {
+				t.Fatalf("\t%s\tShould keep the other client connected : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould keep the other client connected.\n", succeed)
+		}
+	}
+}
+
+// TestDrop test that a client that disconnects is cleaned up and announced.
+func TestDrop(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+	bill := connect(t, n, "bill", false)
+	jill := connect(t, n, "jill", false)
+
+	t.Log("Given the need to clean up dropped clients.")
+	{
+		t.Logf("\tTest 0:\tClient disconnects")
+		{
+			jill.Close()
+
+			if m := bill.Expect(msg.Leave); m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould be told jill left : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould be told jill left.\n", succeed)
+
+			bill.ExpectNone(msg.Leave)
+			t.Logf("\t%s\tShould be told only once.\n", succeed)
+
+			if _, err := n.CC.GetID("jill"); err == nil {
+				t.Fatalf("\t%s\tShould remove the client from the cache.\n", failed)
+			}
+			t.Logf("\t%s\tShould remove the client from the cache.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tListener reports a drop for information")
+		{
+			client, err := n.CC.GetID("bill")
+			if err != nil {
+				t.Fatalf("\t%s\tShould find the client : %v\n", failed, err)
+			}
+			process.Event(n.CC, n.NATS, n.HB, tcp.EvtDrop, tcp.TypInfo, client.TCPAddr.String(), "connect dropped")
+
+			if _, err := n.CC.GetID("bill"); err != nil {
+				t.Fatalf("\t%s\tShould leave only on the drop trigger : %v\n", failed, err)
+			}
+			t.Logf("\t%s\tShould leave only on the drop trigger.\n", succeed)
+		}
+	}
+}
+
+// TestLost test that a client whose name was taken by another node is
+// disconnected without being announced offline.
+func TestLost(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+	bill := connect(t, n, "bill", false)
+	jill := connect(t, n, "jill", false)
+
+	t.Log("Given the need to give up names taken by another node.")
+	{
+		t.Logf("\tTest 0:\tClient loses its name")
+		{
+			client, err := n.CC.GetID("jill")
+			if err != nil {
+				t.Fatalf("\t%s\tShould find the client : %v\n", failed, err)
+			}
+			process.Lost(n.CC, n.NATS, n.HB, client)
+
+			if !jill.Disconnected() {
+				t.Fatalf("\t%s\tShould disconnect the client.\n", failed)
+			}
+			if _, err := n.CC.GetID("jill"); err == nil {
+				t.Fatalf("\t%s\tShould remove the client from the cache.\n", failed)
+			}
+			t.Logf("\t%s\tShould disconnect the client.\n", succeed)
+
+			bill.ExpectNone(msg.Leave)
+			t.Logf("\t%s\tShould not announce the client left.\n", succeed)
+		}
+	}
+}
+
+// TestHeartbeat test that clients that stop answering pings are evicted.
+func TestHeartbeat(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 50*time.Millisecond)
+	bill := connect(t, n, "bill", false)
+	jill := connect(t, n, "jill", false)
+
+	t.Log("Given the need to evict unresponsive clients.")
+	{
+		t.Logf("\tTest 0:\tOnly one client answers pings")
+		{
+			done := make(chan struct{})
+			defer close(done)
+
+			go func() {
+				ticker := time.NewTicker(20 * time.Millisecond)
+				defer ticker.Stop()
+
+				pong := msg.Encode(msg.MSG{Sender: bill.Name, Type: msg.Pong})
+				for {
+					select {
+					case <-ticker.C:
+						if _, err := bill.conn.Write(pong); err != nil {
+							return
+						}
+					case <-done:
+						return
+					}
+				}
+			}()
+
+			if !jill.Disconnected() {
+				t.Fatalf("\t%s\tShould disconnect the unresponsive client.\n", failed)
+			}
+			t.Logf("\t%s\tShould disconnect the unresponsive client.\n", succeed)
+
+			if m := bill.Expect(msg.Leave); m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould be told jill left : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould be told jill left.\n", succeed)
+
+			if _, err := n.CC.GetID("bill"); err != nil {
+				t.Fatalf("\t%s\tShould keep the responsive client : %v\n", failed, err)
+			}
+			t.Logf("\t%s\tShould keep the responsive client.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tConnections never register")
+		{
+			conn, err := net.Dial("tcp4", n.Addr())
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to connect : %v\n", failed, err)
+			}
+			defer conn.Close()
+
+			url := "ws" + strings.TrimPrefix(n.ws.URL, "http")
+			ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to connect over a WebSocket : %v\n", failed, err)
+			}
+			defer ws.Close()
+
+			closed := make(chan struct{}, 2)
+			go func() {
+				io.Copy(io.Discard, conn)
+				closed <- struct{}{}
+			}()
+			go func() {
+				for {
+					if _, _, err := ws.ReadMessage(); err != nil {
+						closed <- struct{}{}
+						return
+					}
+				}
+			}()
+
+			for i := 0; i < 2; i++ {
+				select {
+				case <-closed:
+				case <-time.After(wait):
+					t.Fatalf("\t%s\tShould disconnect the connections.\n", failed)
+				}
+			}
+			t.Logf("\t%s\tShould disconnect the connections.\n", succeed)
+		}
+	}
+}
+
+// TestMalformed test that a client sending a malformed frame is dropped
+// without taking the node down.
+func TestMalformed(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+	bill := connect(t, n, "bill", false)
+	jill := connect(t, n, "jill", false)
+
+	t.Log("Given the need to survive malformed frames.")
+	{
+		t.Logf("\tTest 0:\tUnknown message type")
+		{
+			frame := msg.Encode(msg.MSG{Sender: "jill", Type: msg.Message, Data: "hello"})
+			frame[22] = 255
+			if _, err := jill.conn.Write(frame); err != nil {
+				t.Fatalf("\t%s\tShould be able to send the frame : %v\n", failed, err)
+			}
+
+			if !jill.Disconnected() {
+				t.Fatalf("\t%s\tShould disconnect the client.\n", failed)
+			}
+			t.Logf("\t%s\tShould disconnect the client.\n", succeed)
+
+			if m := bill.Expect(msg.Leave); m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould be told jill left : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould be told jill left.\n", succeed)
+
+			cory := connect(t, n, "cory", false)
+			cory.Send(msg.MSG{Type: msg.Message, Data: "still here"})
+			if m := bill.Expect(msg.Message); m.Data != "still here" {
+				t.Fatalf("\t%s\tShould keep serving other clients : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould keep serving other clients.\n", succeed)
+		}
+	}
+}
+
+// TestJSON test that JSON and binary clients can talk to each other.
+func TestJSON(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+	bill := connect(t, n, "bill", false)
+	jill := connectJSON(t, n, "jill")
+
+	t.Log("Given the need to mix codecs.")
+	{
+		t.Logf("\tTest 0:\tJSON and binary clients")
+		{
+			jill.Send(msg.MSG{Recipient: "bill", Type: msg.Message, Data: "from json"})
+			if m := bill.Expect(msg.Message); m.Data != "from json" || m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould deliver from JSON to binary : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould deliver from JSON to binary.\n", succeed)
+
+			bill.Send(msg.MSG{Recipient: "jill", Type: msg.Message, Data: "from binary"})
+			if m := jill.Expect(msg.Message); m.Data != "from binary" || m.Sender != "bill" {
+				t.Fatalf("\t%s\tShould deliver from binary to JSON : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould deliver from binary to JSON.\n", succeed)
+		}
+	}
+}
+
+// TestWebSocket test that WebSocket clients go through the same pipeline.
+func TestWebSocket(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+	bill := connect(t, n, "bill", false)
+	jill := connectWS(t, n, "jill")
+
+	t.Log("Given the need to serve browser clients.")
+	{
+		t.Logf("\tTest 0:\tWebSocket and binary clients")
+		{
+			if m := bill.Expect(msg.Join); m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould be told jill joined : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould be told jill joined.\n", succeed)
+
+			jill.Send(msg.MSG{Type: msg.Message, Data: "from the browser"})
+			if m := bill.Expect(msg.Message); m.Data != "from the browser" || m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould deliver from WebSocket to binary : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould deliver from WebSocket to binary.\n", succeed)
+
+			bill.Send(msg.MSG{Recipient: "jill", Type: msg.Message, Data: "to the browser"})
+			if m := jill.Expect(msg.Message); m.Data != "to the browser" || m.Sender != "bill" {
+				t.Fatalf("\t%s\tShould deliver from binary to WebSocket : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould deliver from binary to WebSocket.\n", succeed)
+
+			jill.Close()
+			if m := bill.Expect(msg.Leave); m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould be told jill left : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould be told jill left.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen a message can't be decoded")
+		{
+			connectWS(t, n, "jane")
+			bill.Expect(msg.Join)
+
+			client, err := n.CC.GetID("jane")
+			if err != nil {
+				t.Fatalf("\t%s\tShould find the client : %v\n", failed, err)
+			}
+			req := tcp.Request{
+				TCPAddr: client.TCPAddr,
+				Data:    []byte("malformed"),
+			}
+			process.Process(n.CC, n.NATS, nil, &req)
+
+			if m := bill.Expect(msg.Leave); m.Sender != "jane" {
+				t.Fatalf("\t%s\tShould drop the WebSocket connection : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould drop the WebSocket connection.\n", succeed)
+		}
+	}
+}
+
+// TestIRC test that IRC clients can chat with the other clients.
+func TestIRC(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+	bill := connect(t, n, "bill", false)
+	jill := connectIRC(t, n, "jill")
+
+	t.Log("Given the need to serve IRC clients.")
+	{
+		t.Logf("\tTest 0:\tWhen an IRC client logs in")
+		{
+			if m := bill.Expect(msg.Join); m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould tell others jill joined : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould tell others jill joined.\n", succeed)
+
+			jill.Send("NICK bill")
+			jill.Expect(" 432 ")
+			t.Logf("\t%s\tShould refuse nick changes.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen chatting")
+		{
+			bill.Send(msg.MSG{Type: msg.Message, Data: "hello all"})
+			jill.Expect(":bill!bill@chatd PRIVMSG &all :hello all")
+			t.Logf("\t%s\tShould deliver broadcasts on &all.\n", succeed)
+
+			bill.Send(msg.MSG{Recipient: "jill", Type: msg.Message, Data: "hi jill"})
+			jill.Expect(":bill!bill@chatd PRIVMSG jill :hi jill")
+			t.Logf("\t%s\tShould deliver direct messages.\n", succeed)
+
+			jill.Send("PRIVMSG bill :hi bill")
+			if m := bill.Expect(msg.Message); m.Sender != "jill" || m.Recipient != "bill" || m.Data != "hi bill" {
+				t.Fatalf("\t%s\tShould send direct messages : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould send direct messages.\n", succeed)
+		}
+
+		t.Logf("\tTest 2:\tWhen using channels")
+		{
+			jill.Send("JOIN #dev")
+			jill.Expect(":jill!jill@chatd JOIN #dev")
+			bill.Send(msg.MSG{Recipient: "#dev", Type: msg.JoinRoom})
+			jill.Expect(":bill!bill@chatd JOIN #dev")
+			t.Logf("\t%s\tShould join the room.\n", succeed)
+
+			jill.Send("WHO #dev")
+			jill.Expect(" 352 jill #dev bill ")
+			jill.Expect(" 315 jill #dev ")
+			t.Logf("\t%s\tShould list the room members.\n", succeed)
+
+			jill.Send("PRIVMSG #dev :to the room")
+			if m := bill.Expect(msg.Message); m.Recipient != "#dev" || m.Data != "to the room" {
+				t.Fatalf("\t%s\tShould send room messages : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould send room messages.\n", succeed)
+
+			jill.Send("PART #dev")
+			jill.Expect(":jill!jill@chatd PART #dev")
+			if m := bill.Expect(msg.LeaveRoom); m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould leave the room : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould leave the room.\n", succeed)
+		}
+
+		t.Logf("\tTest 3:\tWhen pinging and quitting")
+		{
+			jill.Send("PING :token")
+			jill.Expect(":chatd PONG chatd token")
+			t.Logf("\t%s\tShould answer pings.\n", succeed)
+
+			jill.Send("QUIT :bye")
+			if m := bill.Expect(msg.Leave); m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould tell others jill left : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould tell others jill left.\n", succeed)
+		}
+
+		t.Logf("\tTest 4:\tWhen a message can't be decoded")
+		{
+			connectIRC(t, n, "jane")
+			bill.Expect(msg.Join)
+
+			client, err := n.CC.GetID("jane")
+			if err != nil {
+				t.Fatalf("\t%s\tShould find the client : %v\n", failed, err)
+			}
+			req := tcp.Request{
+				TCPAddr: client.TCPAddr,
+				Data:    []byte("malformed"),
+			}
+			process.Process(n.CC, n.NATS, nil, &req)
+
+			if m := bill.Expect(msg.Leave); m.Sender != "jane" {
+				t.Fatalf("\t%s\tShould drop the IRC connection : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould drop the IRC connection.\n", succeed)
+		}
+	}
+}
+
+// TestWebhooks test that the messages sent from a node reach the webhooks.
+func TestWebhooks(t *testing.T) {
+	events := make(chan webhook.Event, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhook.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer srv.Close()
+
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+
+	cfg := webhook.Config{
+		Subscriptions: []webhook.Subscription{
+			{URL: srv.URL, Types: []string{"message"}},
+		},
+	}
+	n.NATS.Config.Webhooks = webhook.Start(cfg)
+	defer n.NATS.Config.Webhooks.Stop()
+
+	bill := connect(t, n, "bill", false)
+
+	t.Log("Given the need to pipe messages into other tools.")
+	{
+		t.Logf("\tTest 0:\tWhen a client sends a message")
+		{
+			bill.Send(msg.MSG{Recipient: "#dev", Type: msg.Message, Data: "hello"})
+
+			select {
+			case e := <-events:
+				if e.Sender != "bill" || e.Recipient != "#dev" || e.Data != "hello" {
+					t.Fatalf("\t%s\tShould post the message : got%v\n", failed, e)
+				}
+			case <-time.After(wait):
+				t.Fatalf("\t%s\tShould post the message.\n", failed)
+			}
+			t.Logf("\t%s\tShould post the message.\n", succeed)
+		}
+	}
+}
+
+// TestAPI test that scripts can post into rooms over HTTP.
+func TestAPI(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n1 := startNode(t, b, "", 0)
+	n2 := startNode(t, b, "", 0)
+
+	bill := connect(t, n2, "bill", false)
+	bill.Send(msg.MSG{Recipient: "#dev", Type: msg.JoinRoom})
+	eventually(t, "bill in #dev", func() bool {
+		return len(n2.NATS.Config.Rooms.Members("#dev")) == 1
+	})
+
+	api := process.API{
+		NATS:   n1.NATS,
+		Name:   "api",
+		Token:  "t0k3n",
+		Sender: "ci",
+	}
+	srv := httptest.NewServer(&api)
+	defer srv.Close()
+
+	if err := api.Claim(n1.CC, srv.Listener.Addr().(*net.TCPAddr)); err != nil {
+		t.Fatalf("Should be able to claim the name of the API : %v", err)
+	}
+
+	post := func(path, token, body string) int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Should be able to post : %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Log("Given the need to post into rooms over HTTP.")
+	{
+		t.Logf("\tTest 0:\tWhen the request is authenticated")
+		{
+			if status := post("/rooms/dev/messages", "t0k3n", `{"data":"build passed"}`); status != http.StatusAccepted {
+				t.Fatalf("\t%s\tShould accept the message : got %d\n", failed, status)
+			}
+			t.Logf("\t%s\tShould accept the message.\n", succeed)
+
+			if m := bill.Expect(msg.Message); m.Sender != "ci" || m.Recipient != "#dev" || m.Data != "build passed" {
+				t.Fatalf("\t%s\tShould deliver the message to the room on every node : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould deliver the message to the room on every node.\n", succeed)
+
+			if status := post("/rooms/dev/messages", "t0k3n", `{}`); status != http.StatusBadRequest {
+				t.Fatalf("\t%s\tShould refuse empty messages : got %d\n", failed, status)
+			}
+			t.Logf("\t%s\tShould refuse empty messages.\n", succeed)
+
+			if status := post("/rooms/dev", "t0k3n", `{"data":"lost"}`); status != http.StatusNotFound {
+				t.Fatalf("\t%s\tShould refuse unknown paths : got %d\n", failed, status)
+			}
+			t.Logf("\t%s\tShould refuse unknown paths.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen the request is not authenticated")
+		{
+			for _, token := range []string{"", "wrong"} {
+				if status := post("/rooms/dev/messages", token, `{"data":"intruder"}`); status != http.StatusUnauthorized {
+					t.Fatalf("\t%s\tShould refuse the message : got %d\n", failed, status)
+				}
+			}
+			t.Logf("\t%s\tShould refuse the message.\n", succeed)
+
+			bill.ExpectNone(msg.Message)
+			t.Logf("\t%s\tShould not deliver the message.\n", succeed)
+		}
+
+		t.Logf("\tTest 2:\tWhen a client logs in as the API")
+		{
+			impostor := connect(t, n1, "ci", true)
+			if m := impostor.Expect(msg.InCache); m.Recipient != "ci" {
+				t.Fatalf("\t%s\tShould refuse the name of the API : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould refuse the name of the API.\n", succeed)
+
+			bill.ExpectNone(msg.Join)
+			t.Logf("\t%s\tShould not tell others the API joined.\n", succeed)
+		}
+
+		t.Logf("\tTest 3:\tWhen the sender is invalid or taken")
+		{
+			addr := srv.Listener.Addr().(*net.TCPAddr)
+			for _, sender := range []string{"", "toolongname", "#dev", "bill"} {
+				other := process.API{Name: "api", Sender: sender}
+				if err := other.Claim(n2.CC, addr); err == nil {
+					t.Fatalf("\t%s\tShould refuse the sender [ %s ].\n", failed, sender)
+				}
+			}
+			t.Logf("\t%s\tShould refuse invalid or taken senders.\n", succeed)
+		}
+
+		t.Logf("\tTest 4:\tWhen the API stops")
+		{
+			if err := api.Release(n1.CC); err != nil {
+				t.Fatalf("\t%s\tShould give the name up : %v\n", failed, err)
+			}
+			connect(t, n1, "ci", false)
+			t.Logf("\t%s\tShould give the name up.\n", succeed)
+		}
+	}
+}
+
+// TestBots test that the bots are online and answer the clients.
+func TestBots(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+
+	bots, err := process.BuiltinBots([]string{"echo"})
+	if err != nil {
+		t.Fatalf("Should be able to register the builtin bots : %v", err)
+	}
+	n.NATS.Config.Bots = bots
+
+	t.Log("Given the need to run bots inside chatd.")
+	{
+		t.Logf("\tTest 0:\tWhen a client logs in")
+		{
+			bill := connect(t, n, "bill", false)
+			if m := bill.Expect(msg.Join); m.Sender != "echobot" {
+				t.Fatalf("\t%s\tShould list the bots online : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould list the bots online.\n", succeed)
+
+			bill.Send(msg.MSG{Type: msg.Message, Data: "!echo hello"})
+			if m := bill.Expect(msg.Message); m.Sender != "echobot" || m.Data != "hello" {
+				t.Fatalf("\t%s\tShould answer the commands : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould answer the commands.\n", succeed)
+
+			impostor := connect(t, n, "echobot", true)
+			impostor.Expect(msg.InCache)
+			t.Logf("\t%s\tShould not let clients take the name of a bot.\n", succeed)
+		}
+	}
+}
+
+// TestFiles test that files go through chatd within its limits.
+func TestFiles(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n1 := startNode(t, b, "", 0)
+	n2 := startNode(t, b, "", 0)
+
+	cfg := process.FilesConfig{
+		MaxSize: msg.ChunkSize * 2,
+		Quota:   msg.ChunkSize * 3,
+		Window:  time.Hour,
+	}
+	n1.NATS.Config.Files = process.NewFiles(cfg)
+
+	bill := connect(t, n1, "bill", false)
+	jill := connect(t, n2, "jill", false)
+
+	t.Log("Given the need to send files between clients.")
+	{
+		t.Logf("\tTest 0:\tWhen the file is within the limits")
+		{
+			o := msg.Offer{ID: "a1", Size: msg.ChunkSize + 1, Checksum: "abcd", Name: "notes.txt"}
+			bill.Send(msg.MSG{Recipient: "jill", Type: msg.FileOffer, Data: o.String()})
+			if m := jill.Expect(msg.FileOffer); m.Sender != "bill" || m.Data != o.String() {
+				t.Fatalf("\t%s\tShould deliver the offer : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould deliver the offer.\n", succeed)
+
+			jill.Send(msg.MSG{Recipient: "bill", Type: msg.FileAccept, Data: msg.FileReply("a1", "")})
+			bill.Expect(msg.FileAccept)
+			t.Logf("\t%s\tShould deliver the acceptance.\n", succeed)
+
+			for i, size := range []int{msg.ChunkSize, 1} {
+				c := msg.Chunk{ID: "a1", Seq: i, Data: make([]byte, size)}
+				bill.Send(msg.MSG{Recipient: "jill", Type: msg.FileChunk, Data: c.String()})
+				if m := jill.Expect(msg.FileChunk); m.Data != c.String() {
+					t.Fatalf("\t%s\tShould deliver the chunks : got%v\n", failed, m)
+				}
+			}
+			t.Logf("\t%s\tShould deliver the chunks.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen the file is too large")
+		{
+			o := msg.Offer{ID: "b2", Size: msg.ChunkSize*2 + 1, Checksum: "abcd", Name: "movie.mp4"}
+			bill.Send(msg.MSG{Recipient: "jill", Type: msg.FileOffer, Data: o.String()})
+
+			m := bill.Expect(msg.FileReject)
+			if id, reason := msg.ParseFileReply(m.Data); id != "b2" || reason == "" {
+				t.Fatalf("\t%s\tShould tell the sender why it is refused : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould tell the sender why it is refused.\n", succeed)
+
+			jill.ExpectNone(msg.FileOffer)
+			t.Logf("\t%s\tShould not deliver the offer.\n", succeed)
+		}
+
+		t.Logf("\tTest 2:\tWhen the chunks are sent before the file is accepted")
+		{
+			o := msg.Offer{ID: "c3", Size: 1, Checksum: "abcd", Name: "notes.txt"}
+			bill.Send(msg.MSG{Recipient: "jill", Type: msg.FileOffer, Data: o.String()})
+			jill.Expect(msg.FileOffer)
+
+			c := msg.Chunk{ID: "c3", Data: make([]byte, 1)}
+			bill.Send(msg.MSG{Recipient: "jill", Type: msg.FileChunk, Data: c.String()})
+			if m := bill.Expect(msg.FileReject); !strings.HasPrefix(m.Data, "c3 ") {
+				t.Fatalf("\t%s\tShould refuse the chunks : got%v\n", failed, m)
+			}
+			jill.ExpectNone(msg.FileChunk)
+			t.Logf("\t%s\tShould refuse the chunks.\n", succeed)
+		}
+
+		t.Logf("\tTest 3:\tWhen accepting files over the quota")
+		{
+			for _, id := range []string{"d4", "e5"} {
+				o := msg.Offer{ID: id, Size: msg.ChunkSize, Checksum: "abcd", Name: "notes.txt"}
+				bill.Send(msg.MSG{Recipient: "jill", Type: msg.FileOffer, Data: o.String()})
+				jill.Expect(msg.FileOffer)
+			}
+			t.Logf("\t%s\tShould deliver the offers within what is left of the quota.\n", succeed)
+
+			jill.Send(msg.MSG{Recipient: "bill", Type: msg.FileAccept, Data: msg.FileReply("d4", "")})
+			bill.Expect(msg.FileAccept)
+			t.Logf("\t%s\tShould deliver the acceptance within the quota.\n", succeed)
+
+			jill.Send(msg.MSG{Recipient: "bill", Type: msg.FileAccept, Data: msg.FileReply("e5", "")})
+			for _, c := range []*client{bill, jill} {
+				m := c.Expect(msg.FileReject)
+				if id, reason := msg.ParseFileReply(m.Data); id != "e5" || !strings.Contains(reason, "quota") {
+					t.Fatalf("\t%s\tShould reject the transfer to both clients : got%v\n", failed, m)
+				}
+			}
+			bill.ExpectNone(msg.FileAccept)
+			t.Logf("\t%s\tShould reject the transfer to both clients.\n", succeed)
+		}
+	}
+}
+
+// TestEdit test that messages can only be edited and deleted by their
+// sender or an operator, and that replies and reactions refer to them.
+func TestEdit(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+	n.NATS.Config.History = history.New()
+	n.NATS.Config.Operators = []string{"op"}
+
+	bill := connect(t, n, "bill", false)
+	jill := connect(t, n, "jill", false)
+	op := connect(t, n, "op", false)
+
+	for _, c := range []*client{bill, jill, op} {
+		c.Send(msg.MSG{Recipient: "#go", Type: msg.JoinRoom})
+	}
+	eventually(t, "everyone in the room", func() bool {
+		return len(n.NATS.Config.Rooms.Members("#go")) == 3
+	})
+
+	t.Log("Given the need to edit and delete messages.")
+	{
+		t.Logf("\tTest 0:\tWhen the sender edits the message")
+		{
+			bill.Send(msg.MSG{ID: "m1", Recipient: "#go", Type: msg.Message, Data: "helo"})
+			if m := jill.Expect(msg.Message); m.ID != "m1" {
+				t.Fatalf("\t%s\tShould keep the id given by the client : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould keep the id given by the client.\n", succeed)
+
+			bill.Send(msg.MSG{ID: "m1", Type: msg.Edit, Data: "hello"})
+			if m := jill.Expect(msg.Edit); m.ID != "m1" || m.Recipient != "#go" || m.Data != "hello" {
+				t.Fatalf("\t%s\tShould send the edit where the message was : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould send the edit where the message was.\n", succeed)
+
+			if hm, _ := n.NATS.Config.History.Get("m1"); hm.Data != "hello" {
+				t.Fatalf("\t%s\tShould edit the history : got[%+v]\n", failed, hm)
+			}
+			t.Logf("\t%s\tShould edit the history.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen someone else changes the message")
+		{
+			jill.Send(msg.MSG{ID: "m1", Type: msg.Edit, Data: "hacked"})
+			bill.ExpectNone(msg.Edit)
+			if hm, _ := n.NATS.Config.History.Get("m1"); hm.Data != "hello" {
+				t.Fatalf("\t%s\tShould refuse the edit : got[%+v]\n", failed, hm)
+			}
+			t.Logf("\t%s\tShould refuse the edit.\n", succeed)
+
+			op.Send(msg.MSG{ID: "m1", Type: msg.Delete})
+			if m := jill.Expect(msg.Delete); m.ID != "m1" || m.Recipient != "#go" {
+				t.Fatalf("\t%s\tShould let an operator delete it : got%v\n", failed, m)
+			}
+			if hm, _ := n.NATS.Config.History.Get("m1"); hm.Deleted == nil || hm.Data != "" {
+				t.Fatalf("\t%s\tShould delete it from the history : got[%+v]\n", failed, hm)
+			}
+			t.Logf("\t%s\tShould let an operator delete it.\n", succeed)
+		}
+
+		t.Logf("\tTest 2:\tWhen replying and reacting to the message")
+		{
+			bill.Send(msg.MSG{ID: "m2", Recipient: "#go", Type: msg.Message, Data: "lunch?"})
+			jill.Expect(msg.Message)
+
+			jill.Send(msg.MSG{ID: "m3", ReplyTo: "m2", Recipient: "#go", Type: msg.Message, Data: "yes"})
+			if m := bill.Expect(msg.Message); m.ID != "m3" || m.ReplyTo != "m2" {
+				t.Fatalf("\t%s\tShould deliver the reply : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould deliver the reply.\n", succeed)
+
+			for i, sender := range []*client{jill, op} {
+				sender.Send(msg.MSG{ID: "m2", Type: msg.React, Data: "+1"})
+				exp := msg.Reaction{Emoji: "+1", Count: i + 1}.String()
+				if m := bill.Expect(msg.React); m.ID != "m2" || m.Recipient != "#go" || m.Data != exp {
+					t.Fatalf("\t%s\tShould count the reactions : exp[%s] got%v\n", failed, exp, m)
+				}
+			}
+			t.Logf("\t%s\tShould count the reactions.\n", succeed)
+
+			jill.Send(msg.MSG{ID: "m2", Type: msg.React, Data: "+1"})
+			if m := bill.Expect(msg.React); m.Data != (msg.Reaction{Emoji: "+1", Count: 1}).String() {
+				t.Fatalf("\t%s\tShould take the reaction back : got%v\n", failed, m)
+			}
+			if hm, _ := n.NATS.Config.History.Get("m2"); len(hm.Reactions["+1"]) != 1 || hm.Reactions["+1"][0] != "op" {
+				t.Fatalf("\t%s\tShould keep the reactions in the history : got[%+v]\n", failed, hm)
+			}
+			t.Logf("\t%s\tShould take the reaction back.\n", succeed)
+		}
+
+		t.Logf("\tTest 3:\tWhen the id is missing or already used")
+		{
+			jill.Send(msg.MSG{Recipient: "#go", Type: msg.Message, Data: "hi"})
+			if m := bill.Expect(msg.Message); m.ID == "" {
+				t.Fatalf("\t%s\tShould give the message an id : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould give the message an id.\n", succeed)
+
+			jill.Send(msg.MSG{ID: "m1", Recipient: "#go", Type: msg.Message, Data: "hi"})
+			bill.ExpectNone(msg.Message)
+			if hm, _ := n.NATS.Config.History.Get("m1"); hm.Sender != "bill" {
+				t.Fatalf("\t%s\tShould refuse a message with an id already used : got[%+v]\n", failed, hm)
+			}
+			t.Logf("\t%s\tShould refuse a message with an id already used.\n", succeed)
+		}
+
+		t.Logf("\tTest 4:\tWhen the sender is forged")
+		{
+			eve := connect(t, n, "eve", false)
+
+			eve.SendAs("bill", msg.MSG{ID: "m2", Type: msg.Edit, Data: "hacked"})
+			eve.SendAs("op", msg.MSG{ID: "m2", Type: msg.Delete})
+			jill.ExpectNone(msg.Edit)
+			if hm, _ := n.NATS.Config.History.Get("m2"); hm.Data != "lunch?" || hm.Edited != nil || hm.Deleted != nil {
+				t.Fatalf("\t%s\tShould refuse the changes claimed by the author or an operator : got[%+v]\n", failed, hm)
+			}
+			t.Logf("\t%s\tShould refuse the changes claimed by the author or an operator.\n", succeed)
+
+			bill.Send(msg.MSG{ID: "m4", Recipient: "jill", Type: msg.Message, Data: "just us"})
+			jill.Expect(msg.Message)
+
+			eve.SendAs("jill", msg.MSG{ID: "m4", Type: msg.React, Data: "+1"})
+			eve.SendAs("op", msg.MSG{ID: "m2", Type: msg.React, Data: "x"})
+			bill.ExpectNone(msg.React)
+			m4, _ := n.NATS.Config.History.Get("m4")
+			m2, _ := n.NATS.Config.History.Get("m2")
+			if len(m4.Reactions) != 0 || len(m2.Reactions["x"]) != 0 {
+				t.Fatalf("\t%s\tShould refuse the reactions claimed by someone else : got[%+v] [%+v]\n", failed, m4.Reactions, m2.Reactions)
+			}
+			t.Logf("\t%s\tShould refuse the reactions claimed by someone else.\n", succeed)
+		}
+	}
+}
+
+// TestReadReceipt test that the sender of a direct message is told once the
+// recipient read it.
+func TestReadReceipt(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+	n.NATS.Config.History = history.New()
+
+	bill := connect(t, n, "bill", false)
+	jill := connect(t, n, "jill", false)
+	bob := connect(t, n, "bob", false)
+
+	t.Log("Given the need to know when a direct message is read.")
+	{
+		t.Logf("\tTest 0:\tWhen the recipient reads the message")
+		{
+			bill.Send(msg.MSG{ID: "d1", Recipient: "jill", Type: msg.Message, Data: "hello"})
+			jill.Expect(msg.Message)
+
+			jill.Send(msg.MSG{ID: "d1", Type: msg.ReadReceipt})
+			if m := bill.Expect(msg.ReadReceipt); m.ID != "d1" || m.Sender != "jill" || m.Recipient != "bill" {
+				t.Fatalf("\t%s\tShould send the receipt to the sender : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould send the receipt to the sender.\n", succeed)
+
+			if hm, _ := n.NATS.Config.History.Get("d1"); hm.Read == nil {
+				t.Fatalf("\t%s\tShould mark the message read in the history : got[%+v]\n", failed, hm)
+			}
+			t.Logf("\t%s\tShould mark the message read in the history.\n", succeed)
+
+			jill.Send(msg.MSG{ID: "d1", Type: msg.ReadReceipt})
+			bill.ExpectNone(msg.ReadReceipt)
+			t.Logf("\t%s\tShould send the receipt only once.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen someone else reads the message")
+		{
+			bill.Send(msg.MSG{ID: "d2", Recipient: "jill", Type: msg.Message, Data: "again"})
+			jill.Expect(msg.Message)
+
+			bob.Send(msg.MSG{ID: "d2", Type: msg.ReadReceipt})
+			bill.Send(msg.MSG{ID: "d2", Type: msg.ReadReceipt})
+			bill.ExpectNone(msg.ReadReceipt)
+			if hm, _ := n.NATS.Config.History.Get("d2"); hm.Read != nil {
+				t.Fatalf("\t%s\tShould only let the recipient read it : got[%+v]\n", failed, hm)
+			}
+			t.Logf("\t%s\tShould only let the recipient read it.\n", succeed)
+
+			bob.SendAs("jill", msg.MSG{ID: "d2", Type: msg.ReadReceipt})
+			bill.ExpectNone(msg.ReadReceipt)
+			if hm, _ := n.NATS.Config.History.Get("d2"); hm.Read != nil {
+				t.Fatalf("\t%s\tShould refuse a receipt claimed by the recipient : got[%+v]\n", failed, hm)
+			}
+			t.Logf("\t%s\tShould refuse a receipt claimed by the recipient.\n", succeed)
+		}
+	}
+}
+
+// TestHistoryNodes test that the messages can be changed, read and found
+// from every node, whichever node they were sent from.
+func TestHistoryNodes(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n1 := startNode(t, b, "", 0)
+	n2 := startNode(t, b, "", 0)
+	for _, n := range []*node{n1, n2} {
+		n.NATS.Config.History = history.New()
+		n.NATS.Config.Operators = []string{"op"}
+	}
+
+	bill := connect(t, n1, "bill", false)
+	jill := connect(t, n2, "jill", false)
+	op := connect(t, n2, "op", false)
+
+	for _, c := range []*client{jill, op} {
+		c.Send(msg.MSG{Recipient: "#go", Type: msg.JoinRoom})
+	}
+	eventually(t, "jill and op in #go", func() bool {
+		return len(n2.NATS.Config.Rooms.Members("#go")) == 2
+	})
+
+	t.Log("Given the need to share the history between the nodes.")
+	{
+		t.Logf("\tTest 0:\tWhen the recipient is on another node")
+		{
+			bill.Send(msg.MSG{ID: "h1", Recipient: "jill", Type: msg.Message, Data: "hello"})
+			jill.Expect(msg.Message)
+
+			jill.Send(msg.MSG{ID: "h1", Type: msg.React, Data: "+1"})
+			if m := bill.Expect(msg.React); m.ID != "h1" || m.Data != (msg.Reaction{Emoji: "+1", Count: 1}).String() {
+				t.Fatalf("\t%s\tShould send the reaction to the sender : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould send the reaction to the sender.\n", succeed)
+
+			jill.Send(msg.MSG{ID: "h1", Type: msg.ReadReceipt})
+			if m := bill.Expect(msg.ReadReceipt); m.ID != "h1" || m.Sender != "jill" {
+				t.Fatalf("\t%s\tShould send the receipt to the sender : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould send the receipt to the sender.\n", succeed)
+
+			for _, n := range []*node{n1, n2} {
+				if hm, _ := n.NATS.Config.History.Get("h1"); hm.Read == nil || len(hm.Reactions["+1"]) != 1 {
+					t.Fatalf("\t%s\tShould keep the history of both nodes in sync : got[%+v]\n", failed, hm)
+				}
+			}
+			t.Logf("\t%s\tShould keep the history of both nodes in sync.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen an operator is on another node")
+		{
+			bill.Send(msg.MSG{ID: "h2", Recipient: "#go", Type: msg.Message, Data: "deploy on friday"})
+			jill.Expect(msg.Message)
+
+			op.Send(msg.MSG{ID: "h2", Type: msg.Delete})
+			if m := jill.Expect(msg.Delete); m.ID != "h2" || m.Recipient != "#go" {
+				t.Fatalf("\t%s\tShould let the operator delete it : got%v\n", failed, m)
+			}
+			if hm, _ := n1.NATS.Config.History.Get("h2"); hm.Deleted == nil || hm.Data != "" {
+				t.Fatalf("\t%s\tShould delete it from the history of the sender's node : got[%+v]\n", failed, hm)
+			}
+			t.Logf("\t%s\tShould let the operator delete it.\n", succeed)
+		}
+
+		t.Logf("\tTest 2:\tWhen searching from another node")
+		{
+			bill.Send(msg.MSG{ID: "h3", Recipient: "#go", Type: msg.Message, Data: "lunch tomorrow"})
+			jill.Expect(msg.Message)
+
+			jill.Send(msg.MSG{Type: msg.Search, Data: "lunch"})
+			if m := jill.Expect(msg.Search); m.ID != "h3" {
+				t.Fatalf("\t%s\tShould find the message : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould find the message.\n", succeed)
+		}
+	}
+}
+
+// TestSearch test that the clients and the API search the conversations
+// the clients belong to.
+func TestSearch(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+	n.NATS.Config.History = history.New()
+
+	bill := connect(t, n, "bill", false)
+	jill := connect(t, n, "jill", false)
+	bob := connect(t, n, "bob", false)
+
+	for _, c := range []*client{bill, jill} {
+		c.Send(msg.MSG{Recipient: "#go", Type: msg.JoinRoom})
+	}
+	eventually(t, "bill and jill in #go", func() bool {
+		return len(n.NATS.Config.Rooms.Members("#go")) == 2
+	})
+
+	bill.Send(msg.MSG{ID: "s1", Recipient: "#go", Type: msg.Message, Data: "deploy on friday"})
+	jill.Expect(msg.Message)
+	jill.Send(msg.MSG{ID: "s2", Recipient: "bill", Type: msg.Message, Data: "the deploy key"})
+	bill.Expect(msg.Message)
+	bob.Send(msg.MSG{ID: "s3", Type: msg.Message, Data: "deploy now!"})
+	jill.Expect(msg.Message)
+
+	// search returns the ids of the messages found by the client.
+	search := func(c *client, where string, query string) []string {
+		c.Send(msg.MSG{Recipient: where, Type: msg.Search, Data: query})
+
+		var ids []string
+		for {
+			m := c.Expect(msg.Search)
+			if m.ID == "" {
+				if m.Data != strconv.Itoa(len(ids)) {
+					t.Fatalf("\t%s\tShould end with the number of messages found : got%v\n", failed, m)
+				}
+				return ids
+			}
+			ids = append(ids, m.ID)
+		}
+	}
+
+	t.Log("Given the need to search the history.")
+	{
+		t.Logf("\tTest 0:\tWhen a client searches")
+		{
+			if ids := search(bill, "", "DEPLOY"); strings.Join(ids, " ") != "s3 s2 s1" {
+				t.Fatalf("\t%s\tShould find the messages of its conversations, the latest first : got%v\n", failed, ids)
+			}
+			t.Logf("\t%s\tShould find the messages of its conversations, the latest first.\n", succeed)
+
+			if ids := search(bob, "", "deploy"); strings.Join(ids, " ") != "s3" {
+				t.Fatalf("\t%s\tShould not find the messages of the others : got%v\n", failed, ids)
+			}
+			t.Logf("\t%s\tShould not find the messages of the others.\n", succeed)
+
+			if ids := search(bill, "#go", "deploy"); strings.Join(ids, " ") != "s1" {
+				t.Fatalf("\t%s\tShould search a single room : got%v\n", failed, ids)
+			}
+			if ids := search(bill, "jill", "deploy"); strings.Join(ids, " ") != "s2" {
+				t.Fatalf("\t%s\tShould search a single client : got%v\n", failed, ids)
+			}
+			t.Logf("\t%s\tShould search a single conversation.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen searching with the API")
+		{
+			api := process.API{NATS: n.NATS, Token: "t0k3n"}
+			srv := httptest.NewServer(&api)
+			defer srv.Close()
+
+			get := func(query string, token string) (int, []history.Message) {
+				req, _ := http.NewRequest(http.MethodGet, srv.URL+"/search?"+query, nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("Should be able to search : %v", err)
+				}
+				defer resp.Body.Close()
+
+				var found []history.Message
+				json.NewDecoder(resp.Body).Decode(&found)
+				return resp.StatusCode, found
+			}
+
+			if status, found := get("q=deploy", "t0k3n"); status != http.StatusOK || len(found) != 3 {
+				t.Fatalf("\t%s\tShould search every conversation : got %d %+v\n", failed, status, found)
+			}
+			t.Logf("\t%s\tShould search every conversation.\n", succeed)
+
+			if status, found := get("q=deploy&user=bob", "t0k3n"); status != http.StatusOK || len(found) != 1 || found[0].ID != "s3" {
+				t.Fatalf("\t%s\tShould search the conversations of the user : got %d %+v\n", failed, status, found)
+			}
+			if status, found := get("q=deploy&room=go&limit=5", "t0k3n"); status != http.StatusOK || len(found) != 1 || found[0].ID != "s1" {
+				t.Fatalf("\t%s\tShould search the room : got %d %+v\n", failed, status, found)
+			}
+			t.Logf("\t%s\tShould search the conversations asked for.\n", succeed)
+
+			if status, _ := get("q=deploy", "wrong"); status != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tShould refuse the search : got %d\n", failed, status)
+			}
+			if status, _ := get("user=bob", "t0k3n"); status != http.StatusBadRequest {
+				t.Fatalf("\t%s\tShould refuse a search without words : got %d\n", failed, status)
+			}
+			t.Logf("\t%s\tShould refuse the invalid searches.\n", succeed)
+		}
+
+		t.Logf("\tTest 2:\tWhen the sender is forged")
+		{
+			eve := connect(t, n, "eve", false)
+			eve.SendAs("bill", msg.MSG{Type: msg.Search, Data: "deploy"})
+			eve.ExpectNone(msg.Search)
+
+			eveWS := connectWS(t, n, "evews")
+			eveWS.SendAs("bill", msg.MSG{Type: msg.Search, Data: "deploy"})
+			eveWS.ExpectNone(msg.Search)
+			t.Logf("\t%s\tShould not search as another client.\n", succeed)
+
+			conn, err := net.Dial("tcp4", n.Addr())
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to connect : %v\n", failed, err)
+			}
+			defer conn.Close()
+
+			conn.Write(msg.Encode(msg.MSG{Sender: "bill", Type: msg.Search, Data: "deploy"}))
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			if n, err := conn.Read(make([]byte, 1)); err == nil {
+				t.Fatalf("\t%s\tShould not answer an unregistered connection : got %d bytes\n", failed, n)
+			}
+			t.Logf("\t%s\tShould not answer an unregistered connection.\n", succeed)
+		}
+	}
+}
+
+// TestTyping test that typing notices reach the room or the client they
+// are for and are throttled.
+func TestTyping(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	n := startNode(t, b, "", 0)
+
+	bill := connect(t, n, "bill", false)
+	jill := connect(t, n, "jill", false)
+	bob := connect(t, n, "bob", false)
+
+	for _, c := range []*client{bill, jill} {
+		c.Send(msg.MSG{Recipient: "#go", Type: msg.JoinRoom})
+	}
+	eventually(t, "bill and jill in the room", func() bool {
+		return len(n.NATS.Config.Rooms.Members("#go")) == 2
+	})
+
+	t.Log("Given the need to tell who is typing.")
+	{
+		t.Logf("\tTest 0:\tWhen typing in a room")
+		{
+			bill.Send(msg.MSG{Recipient: "#go", Type: msg.Typing})
+			bill.Send(msg.MSG{Recipient: "#go", Type: msg.Typing})
+			if m := jill.Expect(msg.Typing); m.Sender != "bill" || m.Recipient != "#go" {
+				t.Fatalf("\t%s\tShould tell the members of the room : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould tell the members of the room.\n", succeed)
+
+			jill.ExpectNone(msg.Typing)
+			t.Logf("\t%s\tShould throttle the notices.\n", succeed)
+
+			bob.Send(msg.MSG{Recipient: "#go", Type: msg.Typing})
+			jill.ExpectNone(msg.Typing)
+			t.Logf("\t%s\tShould ignore the notices from outside the room.\n", succeed)
+
+			time.Sleep(time.Second)
+			bob.SendAs("bill", msg.MSG{Recipient: "#go", Type: msg.Typing})
+			jill.ExpectNone(msg.Typing)
+			t.Logf("\t%s\tShould ignore the notices sent as someone else.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen typing a direct message")
+		{
+			bill.Send(msg.MSG{Recipient: "jill", Type: msg.Typing})
+			if m := jill.Expect(msg.Typing); m.Sender != "bill" || m.Recipient != "jill" {
+				t.Fatalf("\t%s\tShould tell the recipient : got%v\n", failed, m)
+			}
+			t.Logf("\t%s\tShould tell the recipient.\n", succeed)
+
+			bill.Send(msg.MSG{Type: msg.Typing})
+			bob.ExpectNone(msg.Typing)
+			t.Logf("\t%s\tShould not tell everyone.\n", succeed)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package process
+
+import (
+	"strconv"
+
+	"chat/internal/platform/history"
+	"chat/pkg/msg"
+)
+
+// maxResults is the number of messages a search returns.
+const maxResults = 20
+
+// Search returns the latest messages holding every word of the query. When
+// name is set only the conversations the client belongs to are searched: the
+// messages sent to everyone, to the rooms it is in and its direct messages.
+// When where is set only the room, or the direct messages with the client,
+// in where are searched.
+func (nts *NATS) Search(query string, name string, where string, limit int) []history.Message {
+	if nts.Config.History == nil {
+		return nil
+	}
+
+	allow := func(hm history.Message) bool {
+		if name != "" && !nts.belongs(name, hm) {
+			return false
+		}
+
+		direct := hm.Recipient != "" && !msg.IsRoom(hm.Recipient)
+		switch {
+		case where == "":
+			return true
+		case msg.IsRoom(where):
+			return hm.Recipient == where
+		case !direct:
+			return false
+		case name == "":
+			return hm.Sender == where || hm.Recipient == where
+		}
+		return (hm.Sender == where && hm.Recipient == name) || (hm.Sender == name && hm.Recipient == where)
+	}
+
+	return nts.Config.History.Search(query, allow, limit)
+}
+
+// belongs reports whether the client is part of the conversation the
+// message was sent to.
+func (nts *NATS) belongs(name string, hm history.Message) bool {
+	switch {
+	case hm.Recipient == "":
+		return true
+	case msg.IsRoom(hm.Recipient):
+		return nts.member(hm.Recipient, name)
+	}
+	return hm.Sender == name || hm.Recipient == name
+}
+
+// searchResults returns the answer to the search the client sent: a Search
+// message for each message found, followed by one without an ID holding the
+// number of messages found.
+func (nts *NATS) searchResults(m msg.MSG) []msg.MSG {
+	found := nts.Search(m.Data, m.Sender, m.Recipient, maxResults)
+
+	results := make([]msg.MSG, 0, len(found)+1)
+	for _, hm := range found {
+		results = append(results, msg.MSG{
+			ID:        hm.ID,
+			ReplyTo:   hm.ReplyTo,
+			Sender:    hm.Sender,
+			Recipient: hm.Recipient,
+			Type:      msg.Search,
+			Data:      hm.Data,
+		})
+	}
+
+	results = append(results, msg.MSG{
+		Recipient: m.Sender,
+		Type:      msg.Search,
+		Data:      strconv.Itoa(len(found)),
+	})
+
+	return results
+}
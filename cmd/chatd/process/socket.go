@@ -1,62 +1,260 @@
 package process
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"log"
 	"net"
-	"strconv"
+	"strings"
 
-	"chat/internal/msg"
 	"chat/internal/platform/cache"
+	"chat/pkg/msg"
 
 	"github.com/ardanlabs/kit/tcp"
+	"github.com/pkg/errors"
 )
 
 // Event writes tcp events.
-func Event(cc *cache.Cache, evt, typ int, ipAddress string, format string, a ...any) {
+func Event(cc cache.SessionStore, nats *NATS, hb *Heartbeat, evt, typ int, ipAddress string, format string, a ...any) {
 	log.Printf("****> EVENT : IP[ %s ] : EVT[%s] TYP[%s] : %s", ipAddress, evtTypes[evt], typTypes[typ], fmt.Sprintf(format, a...))
 
-	if typ == tcp.TypTrigger {
-		switch evt {
-		case tcp.EvtDrop:
-			client, err := cc.GetAddress(ipAddress)
+	switch evt {
+	case tcp.EvtJoin:
+
+		// Connections are watched from the start, the ones that never
+		// register are evicted like the unresponsive clients.
+		if typ == tcp.TypTrigger {
+			tcpAddr, err := parseAddr(ipAddress)
 			if err != nil {
-				log.Printf("****> EVENT : IP[ %s ] : ERROR : already removed from cache.", ipAddress)
+				log.Printf("****> EVENT : IP[ %s ] : ERROR : address : %s", ipAddress, err)
 				return
 			}
+			hb.Track(tcpAddr)
+		}
 
-			if err := cc.Remove(ipAddress); err != nil {
-				log.Printf("****> EVENT : IP[ %s ] : ERROR : removing from cache : %s", ipAddress, err)
-				return
-			}
-			log.Printf("****> EVENT : IP[ %s ] : removed [ %s ] from cache.", ipAddress, client.ID)
+	case tcp.EvtDrop:
+
+		// The trigger is reported once the connection is closed, however
+		// it was dropped. Dropping it also reports it for information.
+		if typ == tcp.TypTrigger {
+			Leave(cc, nats, hb, ipAddress)
 		}
 	}
 }
 
+// Leave removes the client connected on the specified address from the
+// cache and announces to everyone that it left.
+func Leave(cc cache.SessionStore, nats *NATS, hb *Heartbeat, ipAddress string) {
+	leave(cc, nats, hb, ipAddress, true)
+}
+
+// Lost removes the client whose name was taken by another node once its
+// claim expired, and drops its connection. The name is online on the other
+// node, so nobody is told the client left.
+func Lost(cc cache.SessionStore, nats *NATS, hb *Heartbeat, client cache.Client) {
+	log.Printf("****> LOST : IP[ %s ] : [ %s ] is used on another node.", client.TCPAddr, client.ID)
+
+	leave(cc, nats, hb, client.TCPAddr.String(), false)
+
+	if err := nats.Config.Listeners.Drop(client.TCPAddr); err != nil {
+		log.Printf("****> LOST : IP[ %s ] : ERROR : drop : %s", client.TCPAddr, err)
+	}
+}
+
+// leave removes the client connected on the specified address from the
+// cache and its rooms, and announces it left when asked to.
+func leave(cc cache.SessionStore, nats *NATS, hb *Heartbeat, ipAddress string, announce bool) {
+	hb.Forget(ipAddress)
+
+	client, err := cc.GetAddress(ipAddress)
+	if err != nil {
+		log.Printf("****> LEAVE : IP[ %s ] : already removed from cache.", ipAddress)
+		return
+	}
+
+	if err := cc.Remove(ipAddress); err != nil {
+		log.Printf("****> LEAVE : IP[ %s ] : ERROR : removing from cache : %s", ipAddress, err)
+		return
+	}
+	log.Printf("****> LEAVE : IP[ %s ] : removed [ %s ] from cache.", ipAddress, client.ID)
+
+	if nats == nil {
+		return
+	}
+
+	if err := nats.LeaveUser(client.ID); err != nil {
+		log.Printf("****> LEAVE : IP[ %s ] : ERROR : %s", ipAddress, err)
+	}
+	if _, err := nats.LeaveRooms(client.ID); err != nil {
+		log.Printf("****> LEAVE : IP[ %s ] : ERROR : %s", ipAddress, err)
+	}
+
+	if !announce {
+		return
+	}
+
+	m := msg.MSG{
+		Sender: client.ID,
+		Type:   msg.Leave,
+	}
+	if err := nats.SendMsg(m); err != nil {
+		log.Printf("****> LEAVE : IP[ %s ] : ERROR : %s", ipAddress, err)
+	}
+}
+
 // Process handles all the communication logic.
-func Process(cc *cache.Cache, nats *NATS, r *tcp.Request) {
+func Process(cc cache.SessionStore, nats *NATS, hb *Heartbeat, r *tcp.Request) {
 	ipAddress := r.TCPAddr.String()
 
+	// Any data received means the connection is alive.
+	hb.Seen(ipAddress)
+
 	// Decode the message bytes into a msg.MSG.
-	m := msg.Decode(r.Data)
+	m, err := msg.Decode(r.Data)
+	if err != nil {
+		log.Printf("Socket_Process : IP[ %s ] : ERROR : dropping malformed message : %s\n", ipAddress, err)
+		if err := nats.Config.Listeners.Drop(r.TCPAddr); err != nil {
+			log.Printf("Socket_Process : IP[ %s ] : ERROR : drop : %s\n", ipAddress, err)
+		}
+		return
+	}
 	log.Printf("Socket_Process : IP[ %s ] : Inbound : %v\n", ipAddress, m)
 
+	// Pongs only keep the connection alive, there is nothing to deliver.
+	if m.Type == msg.Pong {
+		return
+	}
+
+	// Every message but Init comes from a registered connection, sent as the
+	// client the connection registered as. A connection registers once.
+	client, err := cc.GetAddress(ipAddress)
+	switch {
+	case m.Type == msg.Init && err == nil:
+		log.Printf("Socket_Process : IP[ %s ] : ERROR : already registered as [ %s ]\n", ipAddress, client.ID)
+		return
+	case m.Type != msg.Init && err != nil:
+		log.Printf("Socket_Process : IP[ %s ] : ERROR : dropping message from an unregistered connection\n", ipAddress)
+		return
+	case m.Type != msg.Init && m.Sender != client.ID:
+		log.Printf("Socket_Process : IP[ %s ] : ERROR : dropping message sent as [ %s ] by [ %s ]\n", ipAddress, m.Sender, client.ID)
+		return
+	}
+
+	// Presence, in cache notices, pings and search results only come from
+	// chatd, clients can't send them to anyone.
+	switch {
+	case m.Type == msg.Join, m.Type == msg.Leave, m.Type == msg.InCache, m.Type == msg.Ping,
+		m.Type == msg.Search && m.ID != "":
+		log.Printf("Socket_Process : IP[ %s ] : ERROR : dropping %s message only sent by the server\n", ipAddress, msg.TypeName(m.Type))
+		return
+	}
+
 	// Add client to the cache if this is an init message and the client does not exist in the cache.
+	// Everyone else is told the client joined.
+	// Bots are listed online to the client like everyone else, and their
+	// names can't be taken.
 	if m.Type == msg.Init {
-		if _, err := cc.GetID(m.Sender); err != nil {
-			log.Printf("Socket_Process : IP [ %s ] : Adding client [ '%s' ] to cache\n", r.TCPAddr, m.Sender)
-			cc.Add(m.Sender, r.TCPAddr)
+		if !nats.Config.Bots.Has(m.Sender) && cc.Add(m.Sender, r.TCPAddr) == nil {
+			log.Printf("Socket_Process : IP [ %s ] : Added client [ '%s' ] to cache\n", r.TCPAddr, m.Sender)
+			if err := nats.JoinUser(m.Sender); err != nil {
+				log.Printf("Socket_Process : IP[ %s ] : ERROR : %s\n", ipAddress, err)
+			}
+			for _, name := range nats.Config.Bots.Names() {
+				forwardTCPResponse(r.TCPAddr, msg.MSG{Sender: name, Type: msg.Join}, nats.Config.Listeners)
+			}
+			m = msg.MSG{Sender: m.Sender, Type: msg.Join}
 		} else {
-			tcpAddr := fmt.Sprintf("%s:%s", r.TCPAddr.IP.String(), strconv.Itoa(r.TCPAddr.Port))
-			m = msg.MSG{Sender: m.Sender, Data: tcpAddr, Recipient: m.Sender, Type: msg.InCache}
+			m = msg.MSG{Sender: m.Sender, Data: ipAddress, Recipient: m.Sender, Type: msg.InCache}
+		}
+	}
+
+	// Update the room membership, the members are told about it.
+	switch m.Type {
+	case msg.JoinRoom, msg.LeaveRoom:
+		if !msg.IsRoom(m.Recipient) {
+			log.Printf("Socket_Process : IP[ %s ] : ERROR : invalid room [ %s ]\n", ipAddress, m.Recipient)
+			return
+		}
+
+		f := nats.JoinRoom
+		if m.Type == msg.LeaveRoom {
+			f = nats.LeaveRoom
+		}
+		if err := f(m.Recipient, m.Sender); err != nil {
+			log.Printf("Socket_Process : IP[ %s ] : ERROR : %s\n", ipAddress, err)
+		}
+	}
+
+	// File transfers are held to the limits of the server, the sender is
+	// told why when they are refused.
+	if err := nats.Config.Files.Check(m); err != nil {
+		log.Printf("Socket_Process : IP[ %s ] : ERROR : file : %s\n", ipAddress, err)
+
+		var id string
+		switch m.Type {
+		case msg.FileOffer, msg.FileChunk:
+			id, _, _ = strings.Cut(m.Data, " ")
+		case msg.FileAccept, msg.FileReject:
+			id, _ = msg.ParseFileReply(m.Data)
+		}
+
+		reject := msg.MSG{
+			Sender:    m.Recipient,
+			Recipient: m.Sender,
+			Type:      msg.FileReject,
+			Data:      msg.FileReply(id, err.Error()),
+		}
+		forwardTCPResponse(r.TCPAddr, reject, nats.Config.Listeners)
+		return
+	}
+
+	// Searches are answered by this node from its history, only to the
+	// client searching.
+	if m.Type == msg.Search {
+		for _, result := range nats.searchResults(m) {
+			forwardTCPResponse(r.TCPAddr, result, nats.Config.Listeners)
+		}
+		return
+	}
+
+	// Typing notices are dropped rather than flooding the recipients.
+	if m.Type == msg.Typing {
+		if err := nats.checkTyping(m, ipAddress); err != nil {
+			log.Printf("Socket_Process : IP[ %s ] : typing : %s\n", ipAddress, err)
+			return
+		}
+	}
+
+	// Edits, deletes and reactions are held to the history, they go where
+	// the message was sent. Read receipts go to the sender of the message.
+	switch m.Type {
+	case msg.Edit, msg.Delete, msg.React, msg.ReadReceipt:
+		f := nats.amend
+		switch m.Type {
+		case msg.React:
+			f = nats.react
+		case msg.ReadReceipt:
+			f = nats.read
+		}
+		if m, err = f(m); err != nil {
+			log.Printf("Socket_Process : IP[ %s ] : ERROR : %s : %s\n", ipAddress, msg.TypeName(m.Type), err)
+			return
 		}
 	}
 
 	// Send the message to NATS for processing.
 	if err := nats.SendMsg(m); err != nil {
 		log.Printf("Socket_Process : IP[ %s ] : ERROR : %s\n", ipAddress, err)
+		return
+	}
+
+	// The bots answer the commands they match.
+	for _, reply := range nats.Config.Bots.Replies(m) {
+		log.Printf("Socket_Process : IP[ %s ] : Bot[ %s ] : Reply : %s\n", ipAddress, reply.Sender, reply.Data)
+		if err := nats.SendMsg(reply); err != nil {
+			log.Printf("Socket_Process : IP[ %s ] : ERROR : %s\n", ipAddress, err)
+		}
 	}
 }
 
@@ -82,39 +280,79 @@ var typTypes = []string{
 
 // =============================================================================
 
+// dropError tells the tcp package to close the connection. It only closes
+// connections on errors that are not temporary or on io.EOF, and keeps
+// reading from the connection otherwise.
+type dropError struct {
+	error
+}
+
+// Temporary reports the error is not temporary.
+func (dropError) Temporary() bool {
+	return false
+}
+
 // ConnHandler is required to process data.
 type ConnHandler struct{}
 
-// Bind is called to init a reader and writer.
+// Bind is called to init a reader and writer. Reads are buffered so
+// line based codecs don't read one byte at a time.
 func (ConnHandler) Bind(conn net.Conn) (io.Reader, io.Writer) {
-	return conn, conn
+	return bufio.NewReader(conn), conn
 }
 
 // ReqHandler is required to process client messages.
 type ReqHandler struct {
-	CC   *cache.Cache
-	NATS *NATS
+	CC       cache.SessionStore
+	NATS     *NATS
+	HB       *Heartbeat
+	Codec    msg.Codec // Codec spoken by the clients, the binary protocol when nil.
+	Listener string    // Name of the listener the clients are connected to.
+}
+
+// Event implements the tcp.OptEvent function. The address of the client
+// is tagged with the listener before the event is handled, the accept
+// events are about the listener itself.
+func (req *ReqHandler) Event(evt, typ int, ipAddress string, format string, a ...any) {
+	if tcpAddr, err := net.ResolveTCPAddr("tcp", ipAddress); err == nil && evt != tcp.EvtAccept {
+		ipAddress = listenerAddr(req.Listener, tcpAddr).String()
+	}
+
+	Event(req.CC, req.NATS, req.HB, evt, typ, ipAddress, format, a...)
 }
 
 // Read implements the tcp.ReqHandler interface. It is provided a request
 // value to populate and a io.Reader that was created in the Bind above.
-func (*ReqHandler) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
+func (req *ReqHandler) Read(ipAddress string, reader io.Reader) ([]byte, int, error) {
 
 	// Block on the network for our message.
-	data, n, err := msg.Read(reader)
+	m, err := codec(req.Codec).Read(reader)
 	if err != nil {
 		log.Printf("read : IP[ %s ] : %s", ipAddress, err)
-		return nil, 0, err
+
+		// Keep the connection on temporary network errors.
+		if e, ok := errors.Cause(err).(interface{ Temporary() bool }); ok && e.Temporary() {
+			return nil, 0, errors.Cause(err)
+		}
+
+		// The connection is closed and the client leaves on the drop
+		// event. Malformed frames close the connection too since the
+		// stream can't be trusted anymore.
+		return nil, 0, dropError{err}
 	}
 
+	// Everything past the listener works with the binary protocol.
+	data := msg.Encode(m)
+
 	log.Printf("read : IP[ %s ] : Length[%d]", ipAddress, len(data))
-	return data, n, nil
+	return data, len(data), nil
 }
 
 // Process is used to handle the processing of the message. This method
 // is called on a routine from a pool of routines.
 func (req *ReqHandler) Process(r *tcp.Request) {
-	Process(req.CC, req.NATS, r)
+	r.TCPAddr = listenerAddr(req.Listener, r.TCPAddr)
+	Process(req.CC, req.NATS, req.HB, r)
 }
 
 // RespHandler is required to send messages.
@@ -0,0 +1,75 @@
+package process
+
+import (
+	"sync"
+	"time"
+
+	"chat/pkg/msg"
+
+	"github.com/pkg/errors"
+)
+
+// typingInterval is the shortest time between two typing notices from a
+// connection to the same room or client.
+const typingInterval = time.Second
+
+// maxTyping is the number of notices remembered before the old ones are
+// forgotten.
+const maxTyping = 1024
+
+// typing remembers when the last typing notices were sent.
+type typing struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// allow reports whether a notice can be sent for the key, and remembers it
+// was when it can.
+func (t *typing) allow(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if len(t.last) >= maxTyping {
+		for k, last := range t.last {
+			if now.Sub(last) >= typingInterval {
+				delete(t.last, k)
+			}
+		}
+	}
+
+	if now.Sub(t.last[key]) < typingInterval {
+		return false
+	}
+	t.last[key] = now
+
+	return true
+}
+
+// checkTyping reports why the typing notice is not sent, if it isn't. They
+// only go to the rooms the sender is in or to another client, and are
+// throttled per connection.
+func (nts *NATS) checkTyping(m msg.MSG, ipAddress string) error {
+	switch {
+	case m.Recipient == "", m.Recipient == m.Sender:
+		return errors.New("typing notices go to a room or another client")
+	case msg.IsRoom(m.Recipient) && !nts.member(m.Recipient, m.Sender):
+		return errors.Errorf("not a member of [ %s ]", m.Recipient)
+	}
+
+	if !nts.typing.allow(ipAddress + "/" + m.Recipient) {
+		return errors.New("too many typing notices")
+	}
+
+	return nil
+}
+
+// member reports whether the client connected to this node is in the room.
+func (nts *NATS) member(room string, id string) bool {
+	for _, m := range nts.Config.Rooms.Members(room) {
+		if m == id {
+			return true
+		}
+	}
+	return false
+}
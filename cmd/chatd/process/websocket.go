@@ -0,0 +1,162 @@
+package process
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"chat/internal/platform/cache"
+	"chat/pkg/msg"
+
+	"github.com/ardanlabs/kit/tcp"
+	"github.com/gorilla/websocket"
+)
+
+// wsConn represents a single WebSocket connection.
+type wsConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+// write sends the data as a text frame.
+func (c *wsConn) write(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// WebSocket accepts browser clients. Every text frame holds a message in
+// the JSON format of msg.JSON which goes through the same Process and NATS
+// pipeline as the TCP clients.
+type WebSocket struct {
+	CC   cache.SessionStore
+	NATS *NATS
+	HB   *Heartbeat
+	Name string // Name the addresses of the clients are tagged with.
+
+	upgrader websocket.Upgrader
+
+	mu    sync.Mutex
+	conns map[string]*wsConn
+}
+
+// NewWebSocket returns a WebSocket value ready to be mounted on a http server.
+func NewWebSocket(cc cache.SessionStore) *WebSocket {
+	return &WebSocket{
+		CC:    cc,
+		Name:  "ws",
+		conns: make(map[string]*wsConn),
+	}
+}
+
+// ServeHTTP implements the http.Handler interface. It upgrades the request
+// and reads messages until the connection is closed.
+func (ws *WebSocket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	remote, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		log.Printf("websocket : IP[ %s ] : ERROR : address : %s\n", r.RemoteAddr, err)
+		http.Error(w, "invalid remote address", http.StatusBadRequest)
+		return
+	}
+	tcpAddr := listenerAddr(ws.Name, remote)
+	ipAddress := tcpAddr.String()
+
+	// The upgrader writes the error response itself.
+	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("websocket : IP[ %s ] : ERROR : upgrade : %s\n", ipAddress, err)
+		return
+	}
+
+	ws.mu.Lock()
+	ws.conns[remote.String()] = &wsConn{conn: conn}
+	ws.mu.Unlock()
+	ws.HB.Track(tcpAddr)
+
+	log.Printf("websocket : IP[ %s ] : connected\n", ipAddress)
+
+	defer func() {
+		ws.mu.Lock()
+		delete(ws.conns, remote.String())
+		ws.mu.Unlock()
+
+		conn.Close()
+		Leave(ws.CC, ws.NATS, ws.HB, ipAddress)
+		log.Printf("websocket : IP[ %s ] : disconnected\n", ipAddress)
+	}()
+
+	for {
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("websocket : IP[ %s ] : read : %s\n", ipAddress, err)
+			return
+		}
+
+		if typ != websocket.TextMessage {
+			log.Printf("websocket : IP[ %s ] : ERROR : only text frames are supported\n", ipAddress)
+			return
+		}
+
+		m, err := msg.DecodeJSON(data)
+		if err != nil {
+			log.Printf("websocket : IP[ %s ] : ERROR : dropping malformed message : %s\n", ipAddress, err)
+			return
+		}
+
+		// Everything past the listener works with the binary protocol.
+		d := msg.Encode(m)
+		req := tcp.Request{
+			TCPAddr: tcpAddr,
+			ReadAt:  time.Now().UTC(),
+			Context: r.Context(),
+			Data:    d,
+			Length:  len(d),
+		}
+
+		Process(ws.CC, ws.NATS, ws.HB, &req)
+	}
+}
+
+// Send implements the Conns interface. It writes the response to the
+// WebSocket connection of the client as a text frame.
+func (ws *WebSocket) Send(ctx context.Context, r *tcp.Response) error {
+	c, err := ws.conn(r.TCPAddr)
+	if err != nil {
+		return err
+	}
+
+	// The JSON codec ends messages with a newline, a frame doesn't need it.
+	log.Printf("websocket : IP[ %s ] : write : Length[ %d ]\n", r.TCPAddr, len(r.Data))
+	return c.write(bytes.TrimSuffix(r.Data, []byte("\n")))
+}
+
+// Drop implements the Conns interface. It closes the WebSocket connection
+// of the client, which then leaves.
+func (ws *WebSocket) Drop(tcpAddr *net.TCPAddr) error {
+	c, err := ws.conn(tcpAddr)
+	if err != nil {
+		return err
+	}
+
+	return c.conn.Close()
+}
+
+// conn finds the connection for the specified address.
+func (ws *WebSocket) conn(tcpAddr *net.TCPAddr) (*wsConn, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	c, exists := ws.conns[tcpAddr.String()]
+	if !exists {
+		return nil, fmt.Errorf("IP[ %s ] : disconnected", tcpAddr)
+	}
+
+	return c, nil
+}
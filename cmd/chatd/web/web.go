@@ -0,0 +1,20 @@
+// Package web serves the single page chat client embedded in chatd. The
+// page talks to the WebSocket endpoint using the JSON protocol.
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler returns the handler serving the chat client.
+func Handler() http.Handler {
+
+	// The static directory always exists in the embedded files.
+	root, _ := fs.Sub(static, "static")
+	return http.FileServer(http.FS(root))
+}
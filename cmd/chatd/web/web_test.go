@@ -0,0 +1,43 @@
+package web_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chat/cmd/chatd/web"
+)
+
+const succeed = "\u2713"
+const failed = "\u2717"
+
+// TestHandler test that the chat client is served.
+func TestHandler(t *testing.T) {
+	srv := httptest.NewServer(web.Handler())
+	defer srv.Close()
+
+	t.Log("Given the need to serve the web client.")
+	{
+		t.Logf("\tTest 0:\tWhen requesting the root page")
+		{
+			resp, err := http.Get(srv.URL + "/")
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to get the page : %v", failed, err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("\t%s\tShould receive a 200 : got %d", failed, resp.StatusCode)
+			}
+			t.Logf("\t%s\tShould receive a 200.", succeed)
+
+			body, _ := io.ReadAll(resp.Body)
+			if !strings.Contains(string(body), `"/ws"`) {
+				t.Fatalf("\t%s\tShould serve the client using the WebSocket endpoint.", failed)
+			}
+			t.Logf("\t%s\tShould serve the client using the WebSocket endpoint.", succeed)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// Package bus provides the message bus chatd nodes use to talk to each other.
+package bus
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Set of errors returned by the bus implementations.
+var (
+	ErrClosed  = errors.New("bus closed")
+	ErrTimeout = errors.New("request timed out")
+)
+
+// Msg represents a message delivered by the bus.
+type Msg struct {
+	Subject string
+	Reply   string // Subject to publish the response to a request on.
+	Data    []byte
+}
+
+// Handler is called for every message delivered to a subscription.
+type Handler func(m *Msg)
+
+// Subscription represents interest in a subject.
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// Bus defines the behavior required to exchange messages.
+type Bus interface {
+	Publish(subject string, data []byte) error
+	Subscribe(subject string, h Handler) (Subscription, error)
+	Request(subject string, data []byte, timeout time.Duration) (*Msg, error)
+	Close()
+}
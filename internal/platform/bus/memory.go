@@ -0,0 +1,144 @@
+package bus
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// inboxPrefix is the prefix of the subjects responses to requests are
+// published on.
+const inboxPrefix = "_INBOX."
+
+// Memory is an in-process bus for standalone nodes and tests. Subjects are
+// matched exactly and handlers are called on the publishing goroutine, so
+// a message is fully handled once Publish returns.
+type Memory struct {
+	mu     sync.Mutex
+	subs   map[string]map[int]Handler
+	nextID int
+	closed bool
+}
+
+// NewMemory returns an in-memory bus ready for use.
+func NewMemory() *Memory {
+	return &Memory{
+		subs: make(map[string]map[int]Handler),
+	}
+}
+
+// Publish sends the data to every subscriber of the subject.
+func (b *Memory) Publish(subject string, data []byte) error {
+	return b.publish(&Msg{Subject: subject, Data: data})
+}
+
+// Subscribe registers the handler for the messages published on the subject.
+func (b *Memory) Subscribe(subject string, h Handler) (Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	handlers, exists := b.subs[subject]
+	if !exists {
+		handlers = make(map[int]Handler)
+		b.subs[subject] = handlers
+	}
+
+	b.nextID++
+	handlers[b.nextID] = h
+
+	return &memorySub{bus: b, subject: subject, id: b.nextID}, nil
+}
+
+// Request sends the data to the subscribers of the subject and waits for
+// the first response.
+func (b *Memory) Request(subject string, data []byte, timeout time.Duration) (*Msg, error) {
+	b.mu.Lock()
+	b.nextID++
+	inbox := inboxPrefix + strconv.Itoa(b.nextID)
+	b.mu.Unlock()
+
+	resp := make(chan *Msg, 1)
+	f := func(m *Msg) {
+		select {
+		case resp <- m:
+		default:
+		}
+	}
+
+	sub, err := b.Subscribe(inbox, f)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	if err := b.publish(&Msg{Subject: subject, Reply: inbox, Data: data}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case m := <-resp:
+		return m, nil
+	case <-time.After(timeout):
+		return nil, ErrTimeout
+	}
+}
+
+// Close removes every subscription, the bus can't be used afterwards.
+func (b *Memory) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.subs = make(map[string]map[int]Handler)
+}
+
+// publish delivers the message to the handlers subscribed to its subject.
+func (b *Memory) publish(m *Msg) error {
+	var handlers []Handler
+
+	b.mu.Lock()
+	{
+		if b.closed {
+			b.mu.Unlock()
+			return ErrClosed
+		}
+
+		for _, h := range b.subs[m.Subject] {
+			handlers = append(handlers, h)
+		}
+	}
+	b.mu.Unlock()
+
+	// Every handler gets its own copy of the message.
+	for _, h := range handlers {
+		c := *m
+		h(&c)
+	}
+
+	return nil
+}
+
+// memorySub represents a subscription to the in-memory bus.
+type memorySub struct {
+	bus     *Memory
+	subject string
+	id      int
+}
+
+// Unsubscribe removes the subscription from the bus.
+func (s *memorySub) Unsubscribe() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	handlers := s.bus.subs[s.subject]
+	delete(handlers, s.id)
+	if len(handlers) == 0 {
+		delete(s.bus.subs, s.subject)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package bus_test
+
+import (
+	"testing"
+	"time"
+
+	"chat/internal/platform/bus"
+)
+
+const succeed = "\u2713"
+const failed = "\u2717"
+
+// TestMemory test that the in-memory bus delivers messages.
+func TestMemory(t *testing.T) {
+	b := bus.NewMemory()
+	defer b.Close()
+
+	t.Log("Given the need to test the in-memory bus.")
+	{
+		t.Logf("\tTest 0:\tPublish and subscribe")
+		{
+			var got []string
+			sub, err := b.Subscribe("msg", func(m *bus.Msg) {
+				got = append(got, string(m.Data))
+			})
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to subscribe : %v\n", failed, err)
+			}
+			t.Logf("\t%s\tShould be able to subscribe.\n", succeed)
+
+			if err := b.Publish("msg", []byte("hello")); err != nil {
+				t.Fatalf("\t%s\tShould be able to publish : %v\n", failed, err)
+			}
+			if err := b.Publish("other", []byte("world")); err != nil {
+				t.Fatalf("\t%s\tShould be able to publish : %v\n", failed, err)
+			}
+			t.Logf("\t%s\tShould be able to publish.\n", succeed)
+
+			if len(got) != 1 || got[0] != "hello" {
+				t.Fatalf("\t%s\tShould only receive messages for the subject : got%v\n", failed, got)
+			}
+			t.Logf("\t%s\tShould only receive messages for the subject.\n", succeed)
+
+			if err := sub.Unsubscribe(); err != nil {
+				t.Fatalf("\t%s\tShould be able to unsubscribe : %v\n", failed, err)
+			}
+			b.Publish("msg", []byte("hello"))
+			if len(got) != 1 {
+				t.Fatalf("\t%s\tShould not receive messages after unsubscribing : got%v\n", failed, got)
+			}
+			t.Logf("\t%s\tShould not receive messages after unsubscribing.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tRequest and reply")
+		{
+			_, err := b.Subscribe("echo", func(m *bus.Msg) {
+				b.Publish(m.Reply, m.Data)
+			})
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to subscribe : %v\n", failed, err)
+			}
+
+			m, err := b.Request("echo", []byte("ping"), time.Second)
+			if err != nil {
+				t.Fatalf("\t%s\tShould get a response : %v\n", failed, err)
+			}
+			if string(m.Data) != "ping" {
+				t.Fatalf("\t%s\tShould get the right response : exp[ping] got[%s]\n", failed, m.Data)
+			}
+			t.Logf("\t%s\tShould get the right response.\n", succeed)
+
+			if _, err := b.Request("nobody", nil, 10*time.Millisecond); err != bus.ErrTimeout {
+				t.Fatalf("\t%s\tShould time out without a responder : %v\n", failed, err)
+			}
+			t.Logf("\t%s\tShould time out without a responder.\n", succeed)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package bus
+
+import (
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// NATS is a bus backed by a nats connection.
+type NATS struct {
+	conn *nats.Conn
+}
+
+// NewNATS returns a bus using the specified nats connection. Closing the
+// bus closes the connection.
+func NewNATS(conn *nats.Conn) *NATS {
+	return &NATS{conn: conn}
+}
+
+// Publish sends the data to every subscriber of the subject.
+func (b *NATS) Publish(subject string, data []byte) error {
+	if err := b.conn.Publish(subject, data); err != nil {
+		if err == nats.ErrConnectionClosed {
+			return ErrClosed
+		}
+		return errors.Wrapf(err, "publishing to subject : %s", subject)
+	}
+	return nil
+}
+
+// Subscribe registers the handler for the messages published on the subject.
+// The subscription is active on the server once Subscribe returns.
+func (b *NATS) Subscribe(subject string, h Handler) (Subscription, error) {
+	f := func(nm *nats.Msg) {
+		h(&Msg{Subject: nm.Subject, Reply: nm.Reply, Data: nm.Data})
+	}
+
+	sub, err := b.conn.Subscribe(subject, f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "subscribing to subject : %s", subject)
+	}
+
+	// Make sure the server knows about the interest before returning so
+	// messages published right after are not missed.
+	if err := b.conn.Flush(); err != nil {
+		sub.Unsubscribe()
+		return nil, errors.Wrapf(err, "flushing subscription : %s", subject)
+	}
+
+	return sub, nil
+}
+
+// Request sends the data to a subscriber of the subject and waits for
+// the response.
+func (b *NATS) Request(subject string, data []byte, timeout time.Duration) (*Msg, error) {
+	nm, err := b.conn.Request(subject, data, timeout)
+	if err != nil {
+		if err == nats.ErrTimeout {
+			return nil, ErrTimeout
+		}
+		return nil, errors.Wrapf(err, "requesting on subject : %s", subject)
+	}
+	return &Msg{Subject: nm.Subject, Reply: nm.Reply, Data: nm.Data}, nil
+}
+
+// Close closes the nats connection.
+func (b *NATS) Close() {
+	b.conn.Close()
+}
@@ -12,7 +12,7 @@ type Client struct {
 	TCPAddr *net.TCPAddr
 }
 
-// Cache maintains client connections
+// Cache maintains client connections in memory.
 type Cache struct {
 	clients   map[string]Client
 	addresses map[string]string
@@ -42,6 +42,19 @@ func (c *Cache) Get(id string) []Client {
 	return clients
 }
 
+// All returns the current set of clients.
+func (c *Cache) All() []Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clients := make([]Client, 0, len(c.clients))
+	for _, client := range c.clients {
+		clients = append(clients, client)
+	}
+
+	return clients
+}
+
 // Add adds a client value to the cache.
 func (c *Cache) Add(id string, tcpAddr *net.TCPAddr) error {
 	c.mu.Lock()
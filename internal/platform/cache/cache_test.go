@@ -76,3 +76,37 @@ func TestCache(t *testing.T) {
 		}
 	}
 }
+
+// TestCacheAll test that the cache returns every client.
+func TestCacheAll(t *testing.T) {
+	cc := cache.New()
+
+	ids := []string{"bill", "jill", "cory"}
+
+	t.Log("Given the need to iterate over the cache.")
+	{
+		t.Logf("\tTest 0:\tAll clients %v", ids)
+		{
+			for i, id := range ids {
+				tcpAddr := net.TCPAddr{
+					IP:   net.IPv4(127, 0, 0, 1),
+					Port: 6000 + i,
+				}
+				if err := cc.Add(id, &tcpAddr); err != nil {
+					t.Fatalf("\t%s\tShould be able to add client [ %s ] : %v\n", failed, id, err)
+				}
+			}
+			t.Logf("\t%s\tShould be able to add the clients.\n", succeed)
+
+			if n := len(cc.All()); n != len(ids) {
+				t.Fatalf("\t%s\tShould get all the clients : exp[%d] got[%d]\n", failed, len(ids), n)
+			}
+			t.Logf("\t%s\tShould get all the clients.\n", succeed)
+
+			if n := len(cc.Get(ids[0])); n != len(ids)-1 {
+				t.Fatalf("\t%s\tShould get all the other clients : exp[%d] got[%d]\n", failed, len(ids)-1, n)
+			}
+			t.Logf("\t%s\tShould get all the other clients.\n", succeed)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package cache
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// kvClient is the prefix of the keys the clients are stored under inside the
+// bucket.
+const kvClient = "client."
+
+// KV maintains the client connections of this node in memory and claims
+// their ids in a NATS key-value bucket, so an id is only used once across
+// the nodes. The claims expire unless the node keeps refreshing them, the
+// ids of a node that stopped are freed once the ttl is over.
+type KV struct {
+	kv    nats.KeyValue
+	ttl   time.Duration
+	local *Cache
+
+	// revs holds the revision of the claim of each client of this node.
+	mu   sync.Mutex
+	revs map[string]uint64
+	lost func(Client)
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewKV returns a KV value bound to the specified bucket, creating the
+// bucket when it does not exist yet. The claims are refreshed in the
+// background until Close is called.
+func NewKV(conn *nats.Conn, bucket string, ttl time.Duration) (*KV, error) {
+	if ttl <= 0 {
+		return nil, errors.New("the session ttl must be positive")
+	}
+
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, errors.Wrap(err, "JetStream context")
+	}
+
+	kv, err := js.KeyValue(bucket)
+	switch {
+	case errors.Is(err, nats.ErrBucketNotFound):
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket, TTL: ttl})
+
+	case err == nil:
+		// Buckets are given the ttl when they were created with another one.
+		var info *nats.StreamInfo
+		if info, err = js.StreamInfo("KV_" + bucket); err == nil && info.Config.MaxAge != ttl {
+			info.Config.MaxAge = ttl
+			if info.Config.Duplicates > ttl {
+				info.Config.Duplicates = ttl
+			}
+			_, err = js.UpdateStream(&info.Config)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "binding to bucket : %s", bucket)
+	}
+
+	c := KV{
+		kv:       kv,
+		ttl:      ttl,
+		local:    New(),
+		revs:     make(map[string]uint64),
+		shutdown: make(chan struct{}),
+	}
+
+	c.wg.Add(1)
+	go c.refresh()
+
+	return &c, nil
+}
+
+// Close stops refreshing the claims, they expire once the ttl is over.
+func (c *KV) Close() {
+	close(c.shutdown)
+	c.wg.Wait()
+}
+
+// OnLost registers the function called with the clients of this node whose
+// claim expired and was taken by another node. They are still in the cache,
+// the function is expected to disconnect and remove them.
+func (c *KV) OnLost(f func(Client)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lost = f
+}
+
+// Get returns the current set of clients of this node that are not matching
+// the provided id.
+func (c *KV) Get(id string) []Client {
+	return c.local.Get(id)
+}
+
+// All returns the current set of clients of this node.
+func (c *KV) All() []Client {
+	return c.local.All()
+}
+
+// Add adds a client value to the cache. The id is claimed in the bucket
+// first, it fails when any node has a client with the id.
+func (c *KV) Add(id string, tcpAddr *net.TCPAddr) error {
+	client := Client{
+		ID:      id,
+		TCPAddr: tcpAddr,
+	}
+
+	data, err := json.Marshal(client)
+	if err != nil {
+		return errors.Wrapf(err, "encoding client [ %s ]", id)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := kvKey(kvClient, id)
+	rev, err := c.kv.Create(key, data)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyExists) {
+			return fmt.Errorf("client [ %s ] already exists", id)
+		}
+		return errors.Wrapf(err, "storing client [ %s ]", id)
+	}
+
+	if err := c.local.Add(id, tcpAddr); err != nil {
+		c.kv.Delete(key, nats.LastRevision(rev))
+		return err
+	}
+	c.revs[id] = rev
+
+	return nil
+}
+
+// GetID find the client value of this node by id.
+func (c *KV) GetID(id string) (Client, error) {
+	return c.local.GetID(id)
+}
+
+// GetAddress find the client value of this node by address.
+func (c *KV) GetAddress(address string) (Client, error) {
+	return c.local.GetAddress(address)
+}
+
+// Remove removes a client value from the cache and gives up its claim. The
+// claim is left to expire when it can't be deleted.
+func (c *KV) Remove(address string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	client, err := c.local.GetAddress(address)
+	if err != nil {
+		return err
+	}
+
+	if err := c.local.Remove(address); err != nil {
+		return err
+	}
+
+	// Only the claim of this client is deleted, not one another node made
+	// after it expired.
+	if rev, exists := c.revs[client.ID]; exists {
+		c.kv.Delete(kvKey(kvClient, client.ID), nats.LastRevision(rev))
+		delete(c.revs, client.ID)
+	}
+
+	return nil
+}
+
+// refresh renews the claims of the clients of this node before they expire.
+func (c *KV) refresh() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.renew()
+		case <-c.shutdown:
+			return
+		}
+	}
+}
+
+// renew refreshes the claim of every client of this node. A claim that
+// expired is made again when the id is still free, the client is reported
+// lost when another node took it.
+func (c *KV) renew() {
+	var lost []Client
+
+	c.mu.Lock()
+	f := c.lost
+	for id, rev := range c.revs {
+		client, err := c.local.GetID(id)
+		if err != nil {
+			continue
+		}
+
+		data, err := json.Marshal(client)
+		if err != nil {
+			continue
+		}
+
+		key := kvKey(kvClient, id)
+		if rev, err = c.kv.Update(key, data, rev); err != nil {
+			if rev, err = c.kv.Create(key, data); err != nil {
+				if errors.Is(err, nats.ErrKeyExists) {
+					delete(c.revs, id)
+					lost = append(lost, client)
+				}
+				continue
+			}
+		}
+		c.revs[id] = rev
+	}
+	c.mu.Unlock()
+
+	// The function removes the clients from the cache, which takes the lock.
+	for _, client := range lost {
+		if f != nil {
+			f(client)
+		}
+	}
+}
+
+// kvKey builds a bucket key. Names are base64 encoded since ids can hold
+// characters that are not valid in a key.
+func kvKey(prefix, name string) string {
+	return prefix + base64.RawURLEncoding.EncodeToString([]byte(name))
+}
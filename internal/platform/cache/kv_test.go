@@ -0,0 +1,184 @@
+package cache_test
+
+import (
+	"encoding/base64"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"chat/internal/platform/cache"
+
+	"github.com/nats-io/nats-server/v2/server"
+	nats "github.com/nats-io/nats.go"
+)
+
+// startNATS starts a NATS server with JetStream and connects to it.
+func startNATS(t *testing.T) *nats.Conn {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to create the NATS server : %v\n", failed, err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatalf("\t%s\tShould be able to start the NATS server.\n", failed)
+	}
+	t.Cleanup(ns.Shutdown)
+
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to connect to NATS : %v\n", failed, err)
+	}
+	t.Cleanup(conn.Close)
+
+	return conn
+}
+
+// TestKV test that the sessions are kept per node and the ids are claimed
+// across the nodes.
+func TestKV(t *testing.T) {
+	conn := startNATS(t)
+	ttl := time.Second
+
+	// Two nodes sharing the bucket.
+	kv1, err := cache.NewKV(conn, "sessions", ttl)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to bind to the bucket : %v\n", failed, err)
+	}
+	kv2, err := cache.NewKV(conn, "sessions", ttl)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to bind to the bucket : %v\n", failed, err)
+	}
+	defer kv2.Close()
+
+	addr := func(port int) *net.TCPAddr {
+		return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	}
+
+	t.Log("Given the need to share the sessions between nodes.")
+	{
+		t.Logf("\tTest 0:\tBasic mechanics")
+		{
+			if err := kv1.Add("bill", addr(1)); err != nil {
+				t.Fatalf("\t%s\tShould be able to add a client : %v\n", failed, err)
+			}
+			if c, err := kv1.GetAddress(addr(1).String()); err != nil || c.ID != "bill" {
+				t.Fatalf("\t%s\tShould find the client by address : got[%+v] : %v\n", failed, c, err)
+			}
+			t.Logf("\t%s\tShould be able to add a client.\n", succeed)
+
+			if err := kv2.Add("bill", addr(2)); err == nil || err.Error() != "client [ bill ] already exists" {
+				t.Fatalf("\t%s\tShould refuse an id used on another node : %v\n", failed, err)
+			}
+			t.Logf("\t%s\tShould refuse an id used on another node.\n", succeed)
+
+			if _, err := kv2.GetID("bill"); err == nil || len(kv2.All()) != 0 || len(kv2.Get("")) != 0 {
+				t.Fatalf("\t%s\tShould only return the clients of the node : got[%+v]\n", failed, kv2.All())
+			}
+			t.Logf("\t%s\tShould only return the clients of the node.\n", succeed)
+
+			if err := kv1.Remove(addr(1).String()); err != nil {
+				t.Fatalf("\t%s\tShould be able to remove the client : %v\n", failed, err)
+			}
+			if err := kv2.Add("bill", addr(2)); err != nil {
+				t.Fatalf("\t%s\tShould free the id of the client removed : %v\n", failed, err)
+			}
+			t.Logf("\t%s\tShould free the id of the client removed.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tClaim an id at the same time")
+		{
+			var wg sync.WaitGroup
+			errs := make([]error, 2)
+			for i, kv := range []*cache.KV{kv1, kv2} {
+				wg.Add(1)
+				go func(i int, kv *cache.KV) {
+					defer wg.Done()
+					errs[i] = kv.Add("jill", addr(10+i))
+				}(i, kv)
+			}
+			wg.Wait()
+
+			if (errs[0] == nil) == (errs[1] == nil) {
+				t.Fatalf("\t%s\tShould add the client on one node only : got[%v] [%v]\n", failed, errs[0], errs[1])
+			}
+			t.Logf("\t%s\tShould add the client on one node only.\n", succeed)
+		}
+
+		t.Logf("\tTest 2:\tExpire the claims")
+		{
+			if err := kv1.Add("bob", addr(3)); err != nil {
+				t.Fatalf("\t%s\tShould be able to add a client : %v\n", failed, err)
+			}
+
+			time.Sleep(3 * ttl)
+			if err := kv2.Add("bob", addr(4)); err == nil {
+				t.Fatalf("\t%s\tShould keep the claims of a running node.\n", failed)
+			}
+			t.Logf("\t%s\tShould keep the claims of a running node.\n", succeed)
+
+			// A node that crashed stops refreshing its claims.
+			kv1.Close()
+
+			deadline := time.Now().Add(5 * ttl)
+			for kv2.Add("bob", addr(4)) != nil {
+				if time.Now().After(deadline) {
+					t.Fatalf("\t%s\tShould free the ids of a node that stopped.\n", failed)
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			t.Logf("\t%s\tShould free the ids of a node that stopped.\n", succeed)
+		}
+
+		t.Logf("\tTest 3:\tLose a claim to another node")
+		{
+			lost := make(chan cache.Client, 1)
+			kv2.OnLost(func(c cache.Client) {
+				lost <- c
+			})
+
+			if err := kv2.Add("cory", addr(5)); err != nil {
+				t.Fatalf("\t%s\tShould be able to add a client : %v\n", failed, err)
+			}
+
+			// The claim expired and another node took the id before it was
+			// refreshed.
+			js, _ := conn.JetStream()
+			bucket, err := js.KeyValue("sessions")
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to bind to the bucket : %v\n", failed, err)
+			}
+			key := "client." + base64.RawURLEncoding.EncodeToString([]byte("cory"))
+			bucket.Delete(key)
+			if _, err := bucket.Create(key, []byte("{}")); err != nil {
+				t.Fatalf("\t%s\tShould be able to take the id : %v\n", failed, err)
+			}
+
+			select {
+			case c := <-lost:
+				if c.ID != "cory" {
+					t.Fatalf("\t%s\tShould report the client lost : got[%+v]\n", failed, c)
+				}
+			case <-time.After(3 * ttl):
+				t.Fatalf("\t%s\tShould report the client lost.\n", failed)
+			}
+			t.Logf("\t%s\tShould report the client lost.\n", succeed)
+
+			if err := kv2.Remove(addr(5).String()); err != nil {
+				t.Fatalf("\t%s\tShould be able to remove the client : %v\n", failed, err)
+			}
+			if _, err := bucket.Get(key); err != nil {
+				t.Fatalf("\t%s\tShould keep the claim of the other node : %v\n", failed, err)
+			}
+			t.Logf("\t%s\tShould keep the claim of the other node.\n", succeed)
+		}
+	}
+}
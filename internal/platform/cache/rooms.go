@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+)
+
+// Rooms maintains the membership of the rooms clients joined.
+type Rooms struct {
+	rooms map[string]map[string]struct{}
+	mu    sync.Mutex
+}
+
+// NewRooms returns a rooms value ready for use.
+func NewRooms() *Rooms {
+	return &Rooms{
+		rooms: make(map[string]map[string]struct{}),
+	}
+}
+
+// Join adds the client to the specified room.
+func (r *Rooms) Join(room string, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	members, exists := r.rooms[room]
+	if !exists {
+		members = make(map[string]struct{})
+		r.rooms[room] = members
+	}
+	members[id] = struct{}{}
+}
+
+// Leave removes the client from the specified room. Rooms without members
+// are removed.
+func (r *Rooms) Leave(room string, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.leave(room, id)
+}
+
+// LeaveAll removes the client from every room and returns the rooms it left.
+func (r *Rooms) LeaveAll(id string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var left []string
+	for room, members := range r.rooms {
+		if _, exists := members[id]; exists {
+			r.leave(room, id)
+			left = append(left, room)
+		}
+	}
+
+	sort.Strings(left)
+	return left
+}
+
+// Members returns the clients in the specified room.
+func (r *Rooms) Members(room string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	members := make([]string, 0, len(r.rooms[room]))
+	for id := range r.rooms[room] {
+		members = append(members, id)
+	}
+
+	sort.Strings(members)
+	return members
+}
+
+// Names returns the rooms that have at least one member.
+func (r *Rooms) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.rooms))
+	for room := range r.rooms {
+		names = append(names, room)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// leave removes the client from the room. The caller must hold the lock.
+func (r *Rooms) leave(room string, id string) {
+	members, exists := r.rooms[room]
+	if !exists {
+		return
+	}
+
+	delete(members, id)
+	if len(members) == 0 {
+		delete(r.rooms, room)
+	}
+}
@@ -0,0 +1,44 @@
+package cache_test
+
+import (
+	"testing"
+
+	"chat/internal/platform/cache"
+)
+
+// TestRooms test that the room membership works.
+func TestRooms(t *testing.T) {
+	rr := cache.NewRooms()
+
+	t.Log("Given the need to test room membership.")
+	{
+		t.Logf("\tTest 0:\tBasic mechanics")
+		{
+			rr.Join("#go", "bill")
+			rr.Join("#go", "jill")
+			rr.Join("#nats", "bill")
+
+			if members := rr.Members("#go"); len(members) != 2 || members[0] != "bill" || members[1] != "jill" {
+				t.Fatalf("\t%s\tShould have both members in the room : got%v\n", failed, members)
+			}
+			t.Logf("\t%s\tShould have both members in the room.\n", succeed)
+
+			rr.Leave("#go", "jill")
+			if members := rr.Members("#go"); len(members) != 1 {
+				t.Fatalf("\t%s\tShould have one member left in the room : got%v\n", failed, members)
+			}
+			t.Logf("\t%s\tShould have one member left in the room.\n", succeed)
+
+			left := rr.LeaveAll("bill")
+			if len(left) != 2 || left[0] != "#go" || left[1] != "#nats" {
+				t.Fatalf("\t%s\tShould leave every room : got%v\n", failed, left)
+			}
+			t.Logf("\t%s\tShould leave every room.\n", succeed)
+
+			if names := rr.Names(); len(names) != 0 {
+				t.Fatalf("\t%s\tShould remove empty rooms : got%v\n", failed, names)
+			}
+			t.Logf("\t%s\tShould remove empty rooms.\n", succeed)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package cache
+
+import "net"
+
+// SessionStore defines the behavior required to maintain client sessions.
+type SessionStore interface {
+	Add(id string, tcpAddr *net.TCPAddr) error
+	Get(id string) []Client
+	All() []Client
+	GetID(id string) (Client, error)
+	GetAddress(address string) (Client, error)
+	Remove(address string) error
+}
+
+// Make sure the stores satisfy the SessionStore interface.
+var _ SessionStore = (*Cache)(nil)
+var _ SessionStore = (*KV)(nil)
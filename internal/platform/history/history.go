@@ -0,0 +1,361 @@
+// Package history stores the chat messages so they can be edited, deleted,
+// reacted to and searched after being sent. Messages are kept in memory, and
+// in a file of one JSON message per line when the store is opened with a
+// path. They can be exported and imported as JSON lines or transcripts.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Set of errors returned by the store.
+var (
+	ErrNotFound = errors.New("message not found")
+	ErrExists   = errors.New("message id already used")
+	ErrDeleted  = errors.New("message deleted")
+	ErrLocked   = errors.New("history in use by another process")
+)
+
+// Message represents a chat message in the history.
+type Message struct {
+	ID        string     `json:"id"`
+	Sender    string     `json:"sender"`
+	Recipient string     `json:"recipient,omitempty"`
+	ReplyTo   string     `json:"reply_to,omitempty"`
+	Data      string     `json:"data,omitempty"`
+	Time      time.Time  `json:"time"`
+	Edited    *time.Time `json:"edited,omitempty"`
+	Deleted   *time.Time `json:"deleted,omitempty"`
+	Read      *time.Time `json:"read,omitempty"` // When the recipient of a direct message read it.
+
+	Reactions map[string][]string `json:"reactions,omitempty"` // Who reacted, by emoji.
+}
+
+// Store keeps the messages in the order they were sent.
+type Store struct {
+	mu    sync.Mutex
+	msgs  []*Message
+	ids   map[string]*Message
+	words index
+
+	file *os.File // Optional, every change is appended to it.
+	lock *os.File // Locked for as long as the file is open.
+	enc  *json.Encoder
+}
+
+// New returns a store holding the messages in memory.
+func New() *Store {
+	return &Store{
+		ids:   make(map[string]*Message),
+		words: make(index),
+	}
+}
+
+// Open returns a store saving the messages to the file at path. The
+// messages already in the file are loaded, later lines replacing the
+// earlier ones with the same id, and the file is rewritten with only the
+// latest version of each message so edited and deleted data doesn't stay
+// on disk. The lock file next to it is held until the store is closed so
+// only one process at a time changes the file, ErrLocked is returned when
+// it is held already.
+func Open(path string) (*Store, error) {
+	s := New()
+
+	l, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening history lock")
+	}
+	if err := lock(l); err != nil {
+		l.Close()
+		if err == ErrLocked {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "locking history")
+	}
+	s.lock = l
+
+	if err := s.open(path); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// open loads and compacts the file at path, then opens it for the changes.
+func (s *Store) open(path string) error {
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		err = s.load(f)
+		f.Close()
+		if err != nil {
+			return errors.Wrapf(err, "loading history : %s", path)
+		}
+	case !os.IsNotExist(err):
+		return errors.Wrap(err, "opening history")
+	}
+
+	if err := s.compact(path); err != nil {
+		return errors.Wrapf(err, "compacting history : %s", path)
+	}
+
+	s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(err, "opening history")
+	}
+	s.enc = json.NewEncoder(s.file)
+
+	return nil
+}
+
+// Load returns a store holding the messages of the file at path, like Open,
+// without changing the file. The changes to the store are not saved.
+func Load(path string) (*Store, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening history")
+	}
+	defer f.Close()
+
+	s := New()
+	if err := s.load(f); err != nil {
+		return nil, errors.Wrapf(err, "loading history : %s", path)
+	}
+
+	return s, nil
+}
+
+// load reads the messages from the file.
+func (s *Store) load(f *os.File) error {
+	return decodeJSONL(f, func(m Message) {
+		if old, exists := s.ids[m.ID]; exists {
+			s.words.remove(old)
+			*old = m
+			s.words.add(old)
+			return
+		}
+		s.msgs = append(s.msgs, &m)
+		s.ids[m.ID] = &m
+		s.words.add(&m)
+	})
+}
+
+// compact writes the messages in the store to the file at path, replacing
+// it once complete.
+func (s *Store) compact(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, m := range s.msgs {
+		if err := enc.Encode(m); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Close closes the file of the store and releases its lock.
+func (s *Store) Close() error {
+	if s == nil || s.file == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.file.Close()
+	s.lock.Close()
+
+	return err
+}
+
+// Add stores the message, its id can't be used already.
+func (s *Store) Add(m Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.ids[m.ID]; exists {
+		return ErrExists
+	}
+
+	if err := s.save(&m); err != nil {
+		return err
+	}
+
+	s.msgs = append(s.msgs, &m)
+	s.ids[m.ID] = &m
+	s.words.add(&m)
+	return nil
+}
+
+// Range returns the messages sent to the room, all of them when room is
+// empty, between from and to in the order they were sent. A zero from or to
+// leaves the range open on that side.
+func (s *Store) Range(room string, from time.Time, to time.Time) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var msgs []Message
+	for _, m := range s.msgs {
+		switch {
+		case room != "" && m.Recipient != room:
+		case !from.IsZero() && m.Time.Before(from):
+		case !to.IsZero() && !m.Time.Before(to):
+		default:
+			msgs = append(msgs, *m)
+		}
+	}
+
+	return msgs
+}
+
+// Get returns the message with the id.
+func (s *Store) Get(id string) (Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, exists := s.ids[id]
+	if !exists {
+		return Message{}, ErrNotFound
+	}
+
+	return *m, nil
+}
+
+// Edit replaces the data of the message with the id.
+func (s *Store) Edit(id string, data string, t time.Time) error {
+	return s.update(id, func(m *Message) {
+		m.Data = data
+		m.Edited = &t
+	})
+}
+
+// Delete removes the data of the message with the id, which is kept so
+// its place in the conversation is known.
+func (s *Store) Delete(id string, t time.Time) error {
+	return s.update(id, func(m *Message) {
+		m.Data = ""
+		m.Deleted = &t
+	})
+}
+
+// React adds the reaction of the sender with the emoji to the message with
+// the id, or takes it back when the sender already reacted with it. It
+// returns the number of reactions with the emoji.
+func (s *Store) React(id string, sender string, emoji string) (int, error) {
+	var count int
+
+	err := s.update(id, func(m *Message) {
+		reactions := make(map[string][]string, len(m.Reactions)+1)
+		for e, senders := range m.Reactions {
+			reactions[e] = senders
+		}
+
+		var senders []string
+		reacted := false
+		for _, name := range m.Reactions[emoji] {
+			if name == sender {
+				reacted = true
+				continue
+			}
+			senders = append(senders, name)
+		}
+		if !reacted {
+			senders = append(senders, sender)
+		}
+
+		count = len(senders)
+		if count == 0 {
+			delete(reactions, emoji)
+		} else {
+			reactions[emoji] = senders
+		}
+		if len(reactions) == 0 {
+			reactions = nil
+		}
+
+		m.Reactions = reactions
+	})
+
+	return count, err
+}
+
+// MarkRead records when the recipient read the message with the id. It
+// reports whether the message was read for the first time.
+func (s *Store) MarkRead(id string, t time.Time) (bool, error) {
+
+	// Nothing is saved again for the messages already read.
+	if m, err := s.Get(id); err == nil && m.Read != nil {
+		return false, nil
+	}
+
+	var first bool
+
+	err := s.update(id, func(m *Message) {
+		if m.Read == nil {
+			m.Read = &t
+			first = true
+		}
+	})
+
+	return first, err
+}
+
+// update changes the message with the id unless it was deleted.
+func (s *Store) update(id string, f func(m *Message)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, exists := s.ids[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if m.Deleted != nil {
+		return ErrDeleted
+	}
+
+	updated := *m
+	f(&updated)
+
+	if err := s.save(&updated); err != nil {
+		return err
+	}
+
+	s.words.remove(m)
+	*m = updated
+	s.words.add(m)
+	return nil
+}
+
+// save appends the message to the file of the store. The caller must hold
+// the lock.
+func (s *Store) save(m *Message) error {
+	if s.enc == nil {
+		return nil
+	}
+
+	return errors.Wrap(s.enc.Encode(m), "saving history")
+}
@@ -0,0 +1,245 @@
+package history_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"chat/internal/platform/history"
+)
+
+const succeed = "\u2713"
+const failed = "\u2717"
+
+// TestStore test that the messages can be edited and deleted.
+func TestStore(t *testing.T) {
+	s := history.New()
+	now := time.Now().UTC()
+
+	t.Log("Given the need to edit and delete messages.")
+	{
+		t.Logf("\tTest 0:\tBasic mechanics")
+		{
+			if err := s.Add(history.Message{ID: "1", Sender: "bill", Recipient: "#go", Data: "helo", Time: now}); err != nil {
+				t.Fatalf("\t%s\tShould be able to add a message : %v\n", failed, err)
+			}
+			t.Logf("\t%s\tShould be able to add a message.\n", succeed)
+
+			if err := s.Add(history.Message{ID: "1", Sender: "jill"}); err != history.ErrExists {
+				t.Fatalf("\t%s\tShould refuse an id already used : got[%v]\n", failed, err)
+			}
+			t.Logf("\t%s\tShould refuse an id already used.\n", succeed)
+
+			if err := s.Edit("1", "hello", now); err != nil {
+				t.Fatalf("\t%s\tShould be able to edit the message : %v\n", failed, err)
+			}
+			if m, _ := s.Get("1"); m.Data != "hello" || m.Edited == nil || m.Sender != "bill" {
+				t.Fatalf("\t%s\tShould have the edited message : got[%+v]\n", failed, m)
+			}
+			t.Logf("\t%s\tShould have the edited message.\n", succeed)
+
+			for i, exp := range []int{1, 2, 1} {
+				sender := []string{"jill", "bob", "jill"}[i]
+				if n, err := s.React("1", sender, "+1"); err != nil || n != exp {
+					t.Fatalf("\t%s\tShould count the reactions : exp[%d] got[%d] : %v\n", failed, exp, n, err)
+				}
+			}
+			if m, _ := s.Get("1"); len(m.Reactions) != 1 || len(m.Reactions["+1"]) != 1 || m.Reactions["+1"][0] != "bob" {
+				t.Fatalf("\t%s\tShould take a reaction back when repeated : got[%+v]\n", failed, m.Reactions)
+			}
+			t.Logf("\t%s\tShould take a reaction back when repeated.\n", succeed)
+
+			for _, exp := range []bool{true, false} {
+				if first, err := s.MarkRead("1", now); err != nil || first != exp {
+					t.Fatalf("\t%s\tShould tell when the message is read first : exp[%v] got[%v] : %v\n", failed, exp, first, err)
+				}
+			}
+			if m, _ := s.Get("1"); m.Read == nil {
+				t.Fatalf("\t%s\tShould have the message read : got[%+v]\n", failed, m)
+			}
+			t.Logf("\t%s\tShould tell when the message is read first.\n", succeed)
+
+			if err := s.Delete("1", now); err != nil {
+				t.Fatalf("\t%s\tShould be able to delete the message : %v\n", failed, err)
+			}
+			if m, _ := s.Get("1"); m.Data != "" || m.Deleted == nil {
+				t.Fatalf("\t%s\tShould have removed the data : got[%+v]\n", failed, m)
+			}
+			t.Logf("\t%s\tShould have removed the data.\n", succeed)
+
+			if err := s.Edit("1", "again", now); err != history.ErrDeleted {
+				t.Fatalf("\t%s\tShould refuse to edit a deleted message : got[%v]\n", failed, err)
+			}
+			t.Logf("\t%s\tShould refuse to edit a deleted message.\n", succeed)
+
+			if err := s.Edit("2", "hello", now); err != history.ErrNotFound {
+				t.Fatalf("\t%s\tShould refuse to edit an unknown message : got[%v]\n", failed, err)
+			}
+			t.Logf("\t%s\tShould refuse to edit an unknown message.\n", succeed)
+		}
+	}
+}
+
+// TestOpen test that the messages are saved to the file.
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	now := time.Now().UTC()
+
+	t.Log("Given the need to save the history to a file.")
+	{
+		t.Logf("\tTest 0:\tReopen the file")
+		{
+			s, err := history.Open(path)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to open a new file : %v\n", failed, err)
+			}
+			t.Logf("\t%s\tShould be able to open a new file.\n", succeed)
+
+			s.Add(history.Message{ID: "1", Sender: "bill", Data: "secret", Time: now})
+			s.Add(history.Message{ID: "2", Sender: "jill", Data: "helo", Time: now})
+			s.Delete("1", now)
+			s.Edit("2", "hello", now)
+			s.Close()
+
+			if s, err = history.Open(path); err != nil {
+				t.Fatalf("\t%s\tShould be able to open the file again : %v\n", failed, err)
+			}
+
+			m1, err1 := s.Get("1")
+			m2, err2 := s.Get("2")
+			if err1 != nil || err2 != nil || m1.Deleted == nil || m2.Data != "hello" {
+				t.Fatalf("\t%s\tShould load the latest version of the messages : got[%+v] [%+v]\n", failed, m1, m2)
+			}
+			t.Logf("\t%s\tShould load the latest version of the messages.\n", succeed)
+
+			data, _ := os.ReadFile(path)
+			if strings.Count(string(data), "\n") != 2 || strings.Contains(string(data), "secret") {
+				t.Fatalf("\t%s\tShould keep only the latest version in the file : got[%s]\n", failed, data)
+			}
+			t.Logf("\t%s\tShould keep only the latest version in the file.\n", succeed)
+			s.Close()
+		}
+
+		t.Logf("\tTest 1:\tLoad the file")
+		{
+			s, err := history.Load(path)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to load the file : %v\n", failed, err)
+			}
+
+			s.Add(history.Message{ID: "3", Sender: "bob", Time: now})
+			if data, _ := os.ReadFile(path); strings.Contains(string(data), `"bob"`) {
+				t.Fatalf("\t%s\tShould not change the file : got[%s]\n", failed, data)
+			}
+			t.Logf("\t%s\tShould not change the file.\n", succeed)
+		}
+
+		t.Logf("\tTest 2:\tOpen the file twice")
+		{
+			s, err := history.Open(path)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to open the file : %v\n", failed, err)
+			}
+
+			if _, err := history.Open(path); err != history.ErrLocked {
+				t.Fatalf("\t%s\tShould refuse to open the file while it is open : got[%v]\n", failed, err)
+			}
+			t.Logf("\t%s\tShould refuse to open the file while it is open.\n", succeed)
+
+			if _, err := history.Load(path); err != nil {
+				t.Fatalf("\t%s\tShould be able to load the file while it is open : %v\n", failed, err)
+			}
+			t.Logf("\t%s\tShould be able to load the file while it is open.\n", succeed)
+
+			s.Close()
+			if s, err = history.Open(path); err != nil {
+				t.Fatalf("\t%s\tShould be able to open the file once closed : %v\n", failed, err)
+			}
+			s.Close()
+			t.Logf("\t%s\tShould be able to open the file once closed.\n", succeed)
+		}
+	}
+}
+
+// TestRange test that the messages of a room and a time range are returned.
+func TestRange(t *testing.T) {
+	s := history.New()
+	now := time.Now().UTC()
+
+	s.Add(history.Message{ID: "1", Sender: "bill", Recipient: "#go", Time: now})
+	s.Add(history.Message{ID: "2", Sender: "jill", Recipient: "#go", Time: now.Add(time.Hour)})
+	s.Add(history.Message{ID: "3", Sender: "jill", Recipient: "bill", Time: now.Add(time.Hour)})
+	s.Add(history.Message{ID: "4", Sender: "bill", Recipient: "#go", Time: now.Add(2 * time.Hour)})
+
+	ids := func(msgs []history.Message) string {
+		var ids []string
+		for _, m := range msgs {
+			ids = append(ids, m.ID)
+		}
+		return strings.Join(ids, " ")
+	}
+
+	t.Log("Given the need to export part of the history.")
+	{
+		t.Logf("\tTest 0:\tRoom and time range")
+		{
+			if got := ids(s.Range("", time.Time{}, time.Time{})); got != "1 2 3 4" {
+				t.Fatalf("\t%s\tShould return every message in order : got[%s]\n", failed, got)
+			}
+			t.Logf("\t%s\tShould return every message in order.\n", succeed)
+
+			if got := ids(s.Range("#go", now.Add(time.Hour), now.Add(2*time.Hour))); got != "2" {
+				t.Fatalf("\t%s\tShould return the messages of the room in the range : got[%s]\n", failed, got)
+			}
+			t.Logf("\t%s\tShould return the messages of the room in the range.\n", succeed)
+		}
+	}
+}
+
+// TestSearch test that the messages are found by their words.
+func TestSearch(t *testing.T) {
+	s := history.New()
+	now := time.Now().UTC()
+
+	s.Add(history.Message{ID: "1", Sender: "bill", Recipient: "#go", Data: "Lunch at noon?", Time: now})
+	s.Add(history.Message{ID: "2", Sender: "jill", Recipient: "#go", Data: "lunch, yes at noon", Time: now.Add(time.Second)})
+	s.Add(history.Message{ID: "3", Sender: "jill", Recipient: "bill", Data: "secret lunch", Time: now.Add(2 * time.Second)})
+
+	t.Log("Given the need to search the messages.")
+	{
+		t.Logf("\tTest 0:\tSearch the words")
+		{
+			if found := s.Search("LUNCH", nil, 0); len(found) != 3 || found[0].ID != "3" || found[2].ID != "1" {
+				t.Fatalf("\t%s\tShould find the messages with the word, the latest first : got[%+v]\n", failed, found)
+			}
+			t.Logf("\t%s\tShould find the messages with the word, the latest first.\n", succeed)
+
+			if found := s.Search("noon lunch", nil, 1); len(found) != 1 || found[0].ID != "2" {
+				t.Fatalf("\t%s\tShould find the messages with every word up to the limit : got[%+v]\n", failed, found)
+			}
+			t.Logf("\t%s\tShould find the messages with every word up to the limit.\n", succeed)
+
+			inRoom := func(m history.Message) bool { return m.Recipient == "#go" }
+			if found := s.Search("secret", inRoom, 0); len(found) != 0 {
+				t.Fatalf("\t%s\tShould only return the messages allowed : got[%+v]\n", failed, found)
+			}
+			t.Logf("\t%s\tShould only return the messages allowed.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tSearch the messages changed")
+		{
+			s.Edit("1", "dinner at eight", now)
+			s.Delete("3", now)
+
+			if found := s.Search("lunch", nil, 0); len(found) != 1 || found[0].ID != "2" {
+				t.Fatalf("\t%s\tShould not find the words edited or deleted : got[%+v]\n", failed, found)
+			}
+			if found := s.Search("dinner", nil, 0); len(found) != 1 || found[0].ID != "1" {
+				t.Fatalf("\t%s\tShould find the words of the edited message : got[%+v]\n", failed, found)
+			}
+			t.Logf("\t%s\tShould search the latest version of the messages.\n", succeed)
+		}
+	}
+}
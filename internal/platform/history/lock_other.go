@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package history
+
+import "os"
+
+// lock is not supported on this platform, the history is never locked.
+func lock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin
+
+package history
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lock takes the lock held on the file for as long as it is open, it is
+// released when the process exits. ErrLocked is returned when it is taken.
+func lock(f *os.File) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return ErrLocked
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package history
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// index maps the words of the messages to the ids of the messages holding
+// them.
+type index map[string]map[string]struct{}
+
+// add indexes the words of the message.
+func (ix index) add(m *Message) {
+	for _, w := range words(m.Data) {
+		ids, exists := ix[w]
+		if !exists {
+			ids = make(map[string]struct{})
+			ix[w] = ids
+		}
+		ids[m.ID] = struct{}{}
+	}
+}
+
+// remove forgets the words of the message.
+func (ix index) remove(m *Message) {
+	for _, w := range words(m.Data) {
+		delete(ix[w], m.ID)
+		if len(ix[w]) == 0 {
+			delete(ix, w)
+		}
+	}
+}
+
+// words returns the distinct words of the text in lower case. Words are made
+// of letters and digits.
+func words(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]struct{}, len(fields))
+	ws := fields[:0]
+	for _, w := range fields {
+		if _, exists := seen[w]; exists {
+			continue
+		}
+		seen[w] = struct{}{}
+		ws = append(ws, w)
+	}
+
+	return ws
+}
+
+// Search returns the messages holding every word of the query, the latest
+// first. Only the messages allow reports true for are returned, all of them
+// when allow is nil, and at most limit of them unless limit is 0.
+func (s *Store) Search(query string, allow func(m Message) bool, limit int) []Message {
+	terms := words(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+
+	// Start from the rarest word, every message must hold the others too.
+	sort.Slice(terms, func(i, j int) bool {
+		return len(s.words[terms[i]]) < len(s.words[terms[j]])
+	})
+
+	var matched []Message
+next:
+	for id := range s.words[terms[0]] {
+		for _, w := range terms[1:] {
+			if _, exists := s.words[w][id]; !exists {
+				continue next
+			}
+		}
+		matched = append(matched, *s.ids[id])
+	}
+
+	s.mu.Unlock()
+
+	// The messages are checked without holding the store.
+	var found []Message
+	for _, m := range matched {
+		if allow == nil || allow(m) {
+			found = append(found, m)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Time.Equal(found[j].Time) {
+			return found[i].ID > found[j].ID
+		}
+		return found[i].Time.After(found[j].Time)
+	})
+
+	if limit > 0 && len(found) > limit {
+		found = found[:limit]
+	}
+
+	return found
+}
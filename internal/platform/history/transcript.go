@@ -0,0 +1,163 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxLineLength is the longest line read from a file of messages.
+const maxLineLength = 1 << 20
+
+// WriteJSONL writes the messages as one JSON message per line, the format of
+// the history file.
+func WriteJSONL(w io.Writer, msgs []Message) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for i := range msgs {
+		if err := enc.Encode(&msgs[i]); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ReadJSONL reads the messages written by WriteJSONL.
+func ReadJSONL(r io.Reader) ([]Message, error) {
+	var msgs []Message
+	err := decodeJSONL(r, func(m Message) {
+		msgs = append(msgs, m)
+	})
+
+	return msgs, err
+}
+
+// decodeJSONL calls f with every message read.
+func decodeJSONL(r io.Reader, f func(m Message)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineLength)
+
+	for line := 1; scanner.Scan(); line++ {
+		var m Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return errors.Wrapf(err, "line %d", line)
+		}
+		f(m)
+	}
+
+	return scanner.Err()
+}
+
+// WriteText writes the messages as a transcript, one message per line:
+//
+//	2024-01-02T15:04:05.123Z [id] bill: to everyone
+//	2024-01-02T15:04:06Z [id > replyto] jill #go: to the room
+//	2024-01-02T15:04:07Z [id] bill -> jill: direct message
+//
+// The lines of a message after the first start with a tab. Deleted messages
+// are left out, and the edits, reactions and receipts are not written.
+func WriteText(w io.Writer, msgs []Message) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range msgs {
+		if m.Deleted != nil {
+			continue
+		}
+
+		ids := m.ID
+		if m.ReplyTo != "" {
+			ids += " > " + m.ReplyTo
+		}
+
+		to := ""
+		switch {
+		case strings.HasPrefix(m.Recipient, "#"):
+			to = " " + m.Recipient
+		case m.Recipient != "":
+			to = " -> " + m.Recipient
+		}
+
+		data := strings.ReplaceAll(m.Data, "\n", "\n\t")
+		fmt.Fprintf(bw, "%s [%s] %s%s: %s\n", m.Time.UTC().Format(time.RFC3339Nano), ids, m.Sender, to, data)
+	}
+
+	return bw.Flush()
+}
+
+// ReadText reads the messages written by WriteText.
+func ReadText(r io.Reader) ([]Message, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineLength)
+
+	var msgs []Message
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+
+		switch {
+		case text == "":
+			continue
+		case strings.HasPrefix(text, "\t"):
+			if len(msgs) == 0 {
+				return nil, errors.Errorf("line %d : continued line without a message", line)
+			}
+			msgs[len(msgs)-1].Data += "\n" + text[1:]
+			continue
+		}
+
+		m, err := parseText(text)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+		msgs = append(msgs, m)
+	}
+
+	return msgs, scanner.Err()
+}
+
+// parseText parses the first line of a message in a transcript.
+func parseText(text string) (Message, error) {
+	var m Message
+
+	stamp, rest, _ := strings.Cut(text, " ")
+	t, err := time.Parse(time.RFC3339Nano, stamp)
+	if err != nil {
+		return m, errors.Wrap(err, "time")
+	}
+	m.Time = t
+
+	rest, ok := strings.CutPrefix(rest, "[")
+	if !ok {
+		return m, errors.New("missing id")
+	}
+	ids, rest, ok := strings.Cut(rest, "] ")
+	if !ok {
+		return m, errors.New("missing id")
+	}
+	m.ID, m.ReplyTo, _ = strings.Cut(ids, " > ")
+
+	head, data, ok := strings.Cut(rest, ": ")
+	if !ok {
+		return m, errors.New("missing data")
+	}
+	m.Data = data
+
+	switch {
+	case strings.Contains(head, " -> "):
+		m.Sender, m.Recipient, _ = strings.Cut(head, " -> ")
+	case strings.Contains(head, " #"):
+		m.Sender, m.Recipient, _ = strings.Cut(head, " ")
+	default:
+		m.Sender = head
+	}
+
+	if m.ID == "" || m.Sender == "" {
+		return m, errors.New("missing id or sender")
+	}
+
+	return m, nil
+}
@@ -0,0 +1,74 @@
+package history_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"chat/internal/platform/history"
+)
+
+// TestTranscript test that the exported messages are read back.
+func TestTranscript(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 123000000, time.UTC)
+
+	msgs := []history.Message{
+		{ID: "1", Sender: "bill", Data: "to everyone", Time: now},
+		{ID: "2", Sender: "jill", Recipient: "#go", ReplyTo: "1", Data: "two\nlines: here", Time: now.Add(time.Second)},
+		{ID: "3", Sender: "bill", Recipient: "jill", Data: "direct", Time: now.Add(2 * time.Second), Reactions: map[string][]string{"+1": {"jill"}}},
+	}
+
+	t.Log("Given the need to export and import the history.")
+	{
+		t.Logf("\tTest 0:\tJSON lines")
+		{
+			var b bytes.Buffer
+			if err := history.WriteJSONL(&b, msgs); err != nil {
+				t.Fatalf("\t%s\tShould be able to write the messages : %v\n", failed, err)
+			}
+
+			got, err := history.ReadJSONL(&b)
+			if err != nil || !reflect.DeepEqual(got, msgs) {
+				t.Fatalf("\t%s\tShould read back every field : got[%+v] : %v\n", failed, got, err)
+			}
+			t.Logf("\t%s\tShould read back every field.\n", succeed)
+		}
+
+		t.Logf("\tTest 1:\tTranscripts")
+		{
+			deleted := history.Message{ID: "4", Sender: "bob", Time: now, Deleted: &now}
+
+			var b bytes.Buffer
+			if err := history.WriteText(&b, append(msgs, deleted)); err != nil {
+				t.Fatalf("\t%s\tShould be able to write the messages : %v\n", failed, err)
+			}
+
+			exp := "2024-01-02T15:04:05.123Z [1] bill: to everyone\n" +
+				"2024-01-02T15:04:06.123Z [2 > 1] jill #go: two\n\tlines: here\n" +
+				"2024-01-02T15:04:07.123Z [3] bill -> jill: direct\n"
+			if b.String() != exp {
+				t.Fatalf("\t%s\tShould write a line per message : got[%s]\n", failed, b.String())
+			}
+			t.Logf("\t%s\tShould write a line per message.\n", succeed)
+
+			got, err := history.ReadText(&b)
+			if err != nil || len(got) != 3 {
+				t.Fatalf("\t%s\tShould read back the messages : got[%+v] : %v\n", failed, got, err)
+			}
+			msgs[2].Reactions = nil
+			if !reflect.DeepEqual(got, msgs) {
+				t.Fatalf("\t%s\tShould keep the ids, times, senders, recipients and data : got[%+v]\n", failed, got)
+			}
+			t.Logf("\t%s\tShould keep the ids, times, senders, recipients and data.\n", succeed)
+
+			for _, text := range []string{"\tcontinued", "yesterday [1] bill: hi", "2024-01-02T15:04:05Z bill: hi", "2024-01-02T15:04:05Z [1] bill"} {
+				if _, err := history.ReadText(strings.NewReader(text)); err == nil {
+					t.Fatalf("\t%s\tShould refuse the malformed line %q.\n", failed, text)
+				}
+			}
+			t.Logf("\t%s\tShould refuse the malformed lines.\n", succeed)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Package webhook posts chat events to HTTP endpoints. Every subscription
+// has its own queue so a slow endpoint doesn't hold up the others, and
+// failed deliveries are retried with an exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Headers set on every delivery.
+const (
+	HeaderEvent     = "X-Chat-Event"     // Type of the event.
+	HeaderSignature = "X-Chat-Signature" // sha256=<hex HMAC of the body>, when a secret is set.
+)
+
+// queueSize is the number of events waiting for delivery to a subscription
+// before new ones are dropped.
+const queueSize = 1024
+
+// Defaults for the optional settings of the Config.
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultStopTimeout = 5 * time.Second
+)
+
+// Event is the JSON payload posted to the subscriptions.
+type Event struct {
+	ID        string    `json:"id,omitempty"`
+	ReplyTo   string    `json:"reply_to,omitempty"`
+	Sender    string    `json:"sender"`
+	Recipient string    `json:"recipient,omitempty"`
+	Type      string    `json:"type"`
+	Data      string    `json:"data,omitempty"`
+	Direct    bool      `json:"direct,omitempty"` // Sent to a single client.
+	Time      time.Time `json:"time"`
+}
+
+// Subscription represents an endpoint interested in some of the events. An
+// empty filter matches every event but the direct ones, they are only
+// posted to the subscriptions asking for them.
+type Subscription struct {
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret,omitempty"`  // Key used to sign the payloads.
+	Rooms   []string `json:"rooms,omitempty"`   // Only events for these rooms.
+	Senders []string `json:"senders,omitempty"` // Only events from these clients.
+	Types   []string `json:"types,omitempty"`   // Only events of these types.
+	Direct  bool     `json:"direct,omitempty"`  // Direct events too.
+}
+
+// Match reports whether the event is wanted by the subscription.
+func (s Subscription) Match(e Event) bool {
+	if e.Direct && !s.Direct {
+		return false
+	}
+	return match(s.Rooms, e.Recipient) && match(s.Senders, e.Sender) && match(s.Types, e.Type)
+}
+
+// match reports whether the value is in the filter or the filter is empty.
+func match(filter []string, value string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+
+	for _, f := range filter {
+		if f == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads the subscriptions from a JSON file holding an array of them.
+func Load(path string) ([]Subscription, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading webhooks")
+	}
+
+	var subs []Subscription
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return nil, errors.Wrapf(err, "decoding webhooks : %s", path)
+	}
+
+	for i, s := range subs {
+		if s.URL == "" {
+			return nil, errors.Errorf("webhook %d has no url", i)
+		}
+	}
+
+	return subs, nil
+}
+
+// Sign returns the signature of the body for the secret, as set in the
+// HeaderSignature header. Receivers compute it to verify the payloads.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// =============================================================================
+
+// Config represents required configuration for the dispatcher.
+type Config struct {
+	Subscriptions []Subscription
+	Retries       int           // Number of retries after a failed delivery.
+	Backoff       time.Duration // Wait before the first retry, doubled for every retry.
+	StopTimeout   time.Duration // How long Stop waits for the queued events, 5s when zero.
+	Client        *http.Client  // A client timing out after 10s when nil.
+}
+
+// delivery represents an event waiting to be posted.
+type delivery struct {
+	typ  string
+	body []byte
+}
+
+// Dispatcher delivers the events to the subscriptions.
+type Dispatcher struct {
+	Config Config
+
+	queues   []chan delivery
+	wg       sync.WaitGroup
+	shutdown chan struct{}
+	ctx      context.Context // Canceled once Stop gives up on the queued events.
+	cancel   context.CancelFunc
+
+	mu      sync.RWMutex
+	stopped bool
+}
+
+// Start initializes the dispatcher and starts a worker per subscription.
+func Start(cfg Config) *Dispatcher {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: defaultTimeout}
+	}
+	if cfg.StopTimeout == 0 {
+		cfg.StopTimeout = defaultStopTimeout
+	}
+
+	d := Dispatcher{
+		Config:   cfg,
+		queues:   make([]chan delivery, len(cfg.Subscriptions)),
+		shutdown: make(chan struct{}),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	for i, sub := range cfg.Subscriptions {
+		q := make(chan delivery, queueSize)
+		d.queues[i] = q
+
+		d.wg.Add(1)
+		go func(sub Subscription) {
+			defer d.wg.Done()
+			d.work(sub, q)
+		}(sub)
+	}
+
+	log.Printf("webhook : service started : Subscriptions[ %d ]\n", len(cfg.Subscriptions))
+	return &d
+}
+
+// Stop delivers the events already queued and shutdowns the workers. The
+// retries still waiting are abandoned, and so are the deliveries still going
+// on after the StopTimeout. The events dispatched afterwards are dropped.
+func (d *Dispatcher) Stop() {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return
+	}
+	d.stopped = true
+
+	close(d.shutdown)
+	for _, q := range d.queues {
+		close(q)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(d.Config.StopTimeout):
+		log.Println("webhook : ERROR : abandoning the events still queued")
+		d.cancel()
+		<-done
+	}
+	d.cancel()
+
+	log.Println("webhook : service stopped")
+}
+
+// Dispatch queues the event for the subscriptions it matches. It never
+// blocks, events are dropped when a queue is full.
+func (d *Dispatcher) Dispatch(e Event) {
+	if d == nil {
+		return
+	}
+
+	// Marshaling a struct of strings and a time can't fail.
+	body, _ := json.Marshal(e)
+	dl := delivery{typ: e.Type, body: body}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.stopped {
+		return
+	}
+
+	for i, sub := range d.Config.Subscriptions {
+		if !sub.Match(e) {
+			continue
+		}
+
+		select {
+		case d.queues[i] <- dl:
+		default:
+			log.Printf("webhook : URL[ %s ] : ERROR : queue full, dropping %s event\n", sub.URL, e.Type)
+		}
+	}
+}
+
+// work delivers the events queued for the subscription in order.
+func (d *Dispatcher) work(sub Subscription, q chan delivery) {
+	for dl := range q {
+		if d.ctx.Err() != nil {
+			continue
+		}
+		backoff := d.Config.Backoff
+
+	retries:
+		for attempt := 0; ; attempt++ {
+			retry, err := d.post(sub, dl)
+			if err == nil {
+				break
+			}
+			log.Printf("webhook : URL[ %s ] : ERROR : attempt %d : %s\n", sub.URL, attempt+1, err)
+
+			if !retry || attempt == d.Config.Retries {
+				log.Printf("webhook : URL[ %s ] : ERROR : giving up on event\n", sub.URL)
+				break
+			}
+
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+			case <-d.shutdown:
+				break retries
+			}
+		}
+	}
+}
+
+// post delivers the event to the subscription. It reports whether a failed
+// delivery is worth retrying.
+func (d *Dispatcher) post(sub Subscription, dl delivery) (bool, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, sub.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return false, errors.Wrap(err, "creating request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, dl.typ)
+	if sub.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(sub.Secret, dl.body))
+	}
+
+	resp, err := d.Config.Client.Do(req)
+	if err != nil {
+		return true, errors.Wrap(err, "posting event")
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, errors.Errorf("status %d", resp.StatusCode)
+	}
+
+	// The endpoint refused the event, sending it again won't help.
+	return false, errors.Errorf("status %d", resp.StatusCode)
+}
@@ -0,0 +1,236 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"chat/internal/platform/webhook"
+)
+
+const succeed = "\u2713"
+const failed = "\u2717"
+
+// received represents a request the test receiver got.
+type received struct {
+	event     webhook.Event
+	typ       string
+	signature string
+	body      []byte
+}
+
+// receiver starts a http server that answers with the status returned by
+// the function and reports the requests on the channel.
+func receiver(t *testing.T, status func() int) (string, chan received) {
+	ch := make(chan received, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		var e webhook.Event
+		json.Unmarshal(body, &e)
+
+		ch <- received{
+			event:     e,
+			typ:       r.Header.Get(webhook.HeaderEvent),
+			signature: r.Header.Get(webhook.HeaderSignature),
+			body:      body,
+		}
+		w.WriteHeader(status())
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL, ch
+}
+
+// next waits for the next request on the channel.
+func next(t *testing.T, ch chan received) received {
+	t.Helper()
+
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatalf("\t%s\tShould receive a request.", failed)
+	}
+	return received{}
+}
+
+// none makes sure no request is received for a short while.
+func none(t *testing.T, ch chan received) {
+	t.Helper()
+
+	select {
+	case r := <-ch:
+		t.Fatalf("\t%s\tShould not receive a request : got %s", failed, r.body)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// TestDispatch test that events are signed and delivered to the matching
+// subscriptions.
+func TestDispatch(t *testing.T) {
+	ok := func() int { return http.StatusOK }
+	all, allCh := receiver(t, ok)
+	dev, devCh := receiver(t, ok)
+	dms, dmsCh := receiver(t, ok)
+
+	cfg := webhook.Config{
+		Subscriptions: []webhook.Subscription{
+			{URL: all, Secret: "s3cr3t"},
+			{URL: dev, Rooms: []string{"#dev"}, Types: []string{"message"}},
+			{URL: dms, Senders: []string{"jill"}, Direct: true},
+		},
+	}
+	d := webhook.Start(cfg)
+	defer d.Stop()
+
+	t.Log("Given the need to deliver events to webhooks.")
+	{
+		t.Logf("\tTest 0:\tWhen an event matches every subscription")
+		{
+			e := webhook.Event{Sender: "bill", Recipient: "#dev", Type: "message", Data: "hello"}
+			d.Dispatch(e)
+
+			r := next(t, allCh)
+			if r.event.Sender != "bill" || r.event.Data != "hello" || r.typ != "message" {
+				t.Fatalf("\t%s\tShould deliver the event : got %s", failed, r.body)
+			}
+			t.Logf("\t%s\tShould deliver the event.", succeed)
+
+			if r.signature != webhook.Sign("s3cr3t", r.body) {
+				t.Fatalf("\t%s\tShould sign the event : got %s", failed, r.signature)
+			}
+			t.Logf("\t%s\tShould sign the event.", succeed)
+
+			if r := next(t, devCh); r.signature != "" {
+				t.Fatalf("\t%s\tShould not sign without a secret : got %s", failed, r.signature)
+			}
+			t.Logf("\t%s\tShould not sign without a secret.", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen an event doesn't match a subscription")
+		{
+			d.Dispatch(webhook.Event{Sender: "bill", Type: "join"})
+
+			next(t, allCh)
+			none(t, devCh)
+			t.Logf("\t%s\tShould only deliver to the matching subscriptions.", succeed)
+		}
+
+		t.Logf("\tTest 2:\tWhen an event is direct")
+		{
+			d.Dispatch(webhook.Event{Sender: "jill", Recipient: "bill", Type: "message", Data: "secret", Direct: true})
+
+			if r := next(t, dmsCh); r.event.Data != "secret" || !r.event.Direct {
+				t.Fatalf("\t%s\tShould deliver to the subscriptions asking for direct events : got %s", failed, r.body)
+			}
+			t.Logf("\t%s\tShould deliver to the subscriptions asking for direct events.", succeed)
+
+			none(t, allCh)
+			t.Logf("\t%s\tShould not deliver to the other subscriptions.", succeed)
+		}
+
+		t.Logf("\tTest 3:\tWhen the dispatcher is stopped")
+		{
+			d.Stop()
+			d.Dispatch(webhook.Event{Sender: "bill", Type: "join"})
+			none(t, allCh)
+			t.Logf("\t%s\tShould drop the events.", succeed)
+		}
+	}
+}
+
+// TestStopHung test that stopping doesn't wait forever on an endpoint that
+// never answers.
+func TestStopHung(t *testing.T) {
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hung.Close()
+	defer close(release)
+
+	cfg := webhook.Config{
+		Subscriptions: []webhook.Subscription{{URL: hung.URL}},
+		StopTimeout:   100 * time.Millisecond,
+	}
+	d := webhook.Start(cfg)
+
+	t.Log("Given the need to stop with a hung endpoint.")
+	{
+		t.Logf("\tTest 0:\tWhen the endpoint never answers")
+		{
+			for i := 0; i < 3; i++ {
+				d.Dispatch(webhook.Event{Sender: "bill", Type: "message", Data: "hello"})
+			}
+
+			stopped := make(chan struct{})
+			go func() {
+				d.Stop()
+				close(stopped)
+			}()
+
+			select {
+			case <-stopped:
+			case <-time.After(2 * time.Second):
+				t.Fatalf("\t%s\tShould stop once the timeout is over.", failed)
+			}
+			t.Logf("\t%s\tShould stop once the timeout is over.", succeed)
+		}
+	}
+}
+
+// TestRetry test that failed deliveries are retried with a backoff.
+func TestRetry(t *testing.T) {
+	var calls int32
+	flaky, flakyCh := receiver(t, func() int {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	refused, refusedCh := receiver(t, func() int { return http.StatusBadRequest })
+
+	cfg := webhook.Config{
+		Subscriptions: []webhook.Subscription{
+			{URL: flaky},
+			{URL: refused},
+		},
+		Retries: 3,
+		Backoff: 10 * time.Millisecond,
+	}
+	d := webhook.Start(cfg)
+	defer d.Stop()
+
+	t.Log("Given the need to retry failed deliveries.")
+	{
+		t.Logf("\tTest 0:\tWhen the endpoint fails")
+		{
+			d.Dispatch(webhook.Event{Sender: "bill", Type: "message", Data: "hello"})
+
+			start := time.Now()
+			for i := 0; i < 3; i++ {
+				next(t, flakyCh)
+			}
+			none(t, flakyCh)
+			t.Logf("\t%s\tShould retry until it is delivered.", succeed)
+
+			if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+				t.Fatalf("\t%s\tShould back off between retries : took %v", failed, elapsed)
+			}
+			t.Logf("\t%s\tShould back off between retries.", succeed)
+
+			next(t, refusedCh)
+			none(t, refusedCh)
+			t.Logf("\t%s\tShould not retry refused events.", succeed)
+		}
+	}
+}
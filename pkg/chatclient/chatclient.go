@@ -0,0 +1,370 @@
+// Package chatclient is a library to write chat clients and bots in Go. It
+// handles the connection to chatd, the login, the heartbeat and reconnects,
+// and calls the registered handlers for the messages received:
+//
+//	c := chatclient.New(chatclient.Config{Host: ":6000", Name: "echobot"})
+//	c.OnMessage(func(m msg.MSG) {
+//		c.DM(m.Sender, m.Data)
+//	})
+//	if err := c.Connect(); err != nil {
+//		log.Fatal(err)
+//	}
+//	<-c.Done()
+package chatclient
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"chat/pkg/msg"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNameTaken is returned by Err when another client is connected
+	// with the same name.
+	ErrNameTaken = errors.New("name already connected")
+
+	// ErrDisconnected is returned when sending while the client is not
+	// connected.
+	ErrDisconnected = errors.New("disconnected")
+
+	// errStillConnected is returned when reconnecting before chatd noticed
+	// the previous connection is gone.
+	errStillConnected = errors.New("previous connection still connected")
+)
+
+// Handler is called with the messages received. Handlers run one at a time
+// on the goroutine reading from the connection, so they shouldn't block.
+type Handler func(m msg.MSG)
+
+// Config represents required configuration for the client.
+type Config struct {
+	Host      string        // Address of chatd.
+	Name      string        // Name to login with.
+	Reconnect time.Duration // Wait between reconnect attempts, zero disables reconnecting.
+}
+
+// Client represents a connection to chatd.
+type Client struct {
+	Config Config
+
+	mu       sync.Mutex
+	conn     net.Conn
+	rooms    map[string]bool
+	handlers map[uint8][]Handler
+	closed   bool
+	err      error
+
+	done chan struct{}
+}
+
+// New returns a client ready to have its handlers registered and connect.
+func New(cfg Config) *Client {
+	return &Client{
+		Config:   cfg,
+		rooms:    make(map[string]bool),
+		handlers: make(map[uint8][]Handler),
+		done:     make(chan struct{}),
+	}
+}
+
+// On registers a handler for the messages of the specified type. Pings are
+// answered by the client and in cache notices end it, their handlers are
+// called too.
+func (c *Client) On(typ uint8, h Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handlers[typ] = append(c.handlers[typ], h)
+}
+
+// OnMessage registers a handler for the chat messages.
+func (c *Client) OnMessage(h Handler) {
+	c.On(msg.Message, h)
+}
+
+// Connect dials chatd, logs in and starts reading messages.
+func (c *Client) Connect() error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+
+	go c.read(conn, true)
+	return nil
+}
+
+// dial connects to chatd, logs in and joins the rooms the client is in.
+func (c *Client) dial() (net.Conn, error) {
+	conn, err := net.Dial("tcp4", c.Config.Host)
+	if err != nil {
+		return nil, errors.Wrap(err, "dial")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		conn.Close()
+		return nil, ErrDisconnected
+	}
+
+	ms := []msg.MSG{{Type: msg.Init}}
+	for room := range c.rooms {
+		ms = append(ms, msg.MSG{Recipient: room, Type: msg.JoinRoom})
+	}
+
+	for _, m := range ms {
+		m.Sender = c.Config.Name
+		if _, err := conn.Write(msg.Encode(m)); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "login")
+		}
+	}
+
+	c.conn = conn
+	return conn, nil
+}
+
+// read handles the messages received until the connection is lost, then
+// reconnects when configured to.
+func (c *Client) read(conn net.Conn, first bool) {
+	for {
+		err := c.receive(conn, first)
+		conn.Close()
+
+		if !c.reconnect(err) {
+			return
+		}
+
+		if conn, err = c.redial(); err != nil {
+			return
+		}
+		first = false
+	}
+}
+
+// receive answers pings and calls the handlers for the messages received
+// on the connection until it fails.
+func (c *Client) receive(conn net.Conn, first bool) error {
+	for {
+		data, _, err := msg.Read(conn)
+		if err != nil {
+			return err
+		}
+
+		m, err := msg.Decode(data)
+		if err != nil {
+			return err
+		}
+
+		switch m.Type {
+		case msg.Ping:
+			if err := c.Send(msg.MSG{Type: msg.Pong}); err != nil {
+				log.Printf("chatclient : %s : pong : %s", c.Config.Name, err)
+			}
+
+		case msg.InCache:
+			c.call(m)
+			if first {
+				return ErrNameTaken
+			}
+
+			// Once reconnecting the name is taken until chatd notices the
+			// previous connection is gone, login again.
+			return errStillConnected
+		}
+
+		c.call(m)
+	}
+}
+
+// call runs the handlers registered for the type of the message.
+func (c *Client) call(m msg.MSG) {
+	c.mu.Lock()
+	handlers := c.handlers[m.Type]
+	c.mu.Unlock()
+
+	for _, h := range handlers {
+		h(m)
+	}
+}
+
+// reconnect reports whether the client reconnects after losing the
+// connection with the error, and stops it otherwise.
+func (c *Client) reconnect(err error) bool {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+
+	switch {
+	case closed:
+		return false
+	case err == ErrNameTaken, c.Config.Reconnect <= 0:
+		c.stop(err)
+		return false
+	}
+
+	log.Printf("chatclient : %s : disconnected, reconnecting : %s", c.Config.Name, err)
+	return true
+}
+
+// redial connects again until it succeeds or the client is closed.
+func (c *Client) redial() (net.Conn, error) {
+	for {
+		select {
+		case <-time.After(c.Config.Reconnect):
+		case <-c.done:
+			return nil, ErrDisconnected
+		}
+
+		conn, err := c.dial()
+		switch {
+		case err == nil:
+			log.Printf("chatclient : %s : reconnected", c.Config.Name)
+			return conn, nil
+		case err == ErrDisconnected:
+			return nil, err
+		}
+
+		log.Printf("chatclient : %s : %s", c.Config.Name, err)
+	}
+}
+
+// stop ends the client with the error.
+func (c *Client) stop(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	c.closed = true
+	c.err = err
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	close(c.done)
+}
+
+// Close disconnects the client, chatd announces it left.
+func (c *Client) Close() error {
+	c.stop(nil)
+	return nil
+}
+
+// Done returns a channel closed once the client stopped.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the client stopped, nil once closed.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// =============================================================================
+
+// Send writes the message from this client.
+func (c *Client) Send(m msg.MSG) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.conn == nil {
+		return ErrDisconnected
+	}
+
+	m.Sender = c.Config.Name
+	if _, err := c.conn.Write(msg.Encode(m)); err != nil {
+		return errors.Wrap(err, "write")
+	}
+
+	// Remember the rooms to join them again after reconnecting.
+	switch m.Type {
+	case msg.JoinRoom:
+		c.rooms[m.Recipient] = true
+	case msg.LeaveRoom:
+		delete(c.rooms, m.Recipient)
+	}
+
+	return nil
+}
+
+// Broadcast sends the data to everyone.
+func (c *Client) Broadcast(data string) error {
+	return c.Send(msg.MSG{Type: msg.Message, Data: data})
+}
+
+// DM sends the data to a single client.
+func (c *Client) DM(recipient string, data string) error {
+	return c.Send(msg.MSG{Recipient: recipient, Type: msg.Message, Data: data})
+}
+
+// Say sends the data to the members of the room.
+func (c *Client) Say(room string, data string) error {
+	return c.Send(msg.MSG{Recipient: room, Type: msg.Message, Data: data})
+}
+
+// Join adds the client to the room.
+func (c *Client) Join(room string) error {
+	return c.Send(msg.MSG{Recipient: room, Type: msg.JoinRoom})
+}
+
+// Part removes the client from the room.
+func (c *Client) Part(room string) error {
+	return c.Send(msg.MSG{Recipient: room, Type: msg.LeaveRoom})
+}
+
+// Edit replaces the data of a message the client sent.
+func (c *Client) Edit(id string, data string) error {
+	return c.Send(msg.MSG{ID: id, Type: msg.Edit, Data: data})
+}
+
+// Delete deletes a message the client sent.
+func (c *Client) Delete(id string) error {
+	return c.Send(msg.MSG{ID: id, Type: msg.Delete})
+}
+
+// React reacts to a message with the emoji, reacting again with the same
+// emoji takes the reaction back.
+func (c *Client) React(id string, emoji string) error {
+	return c.Send(msg.MSG{ID: id, Type: msg.React, Data: emoji})
+}
+
+// Reply answers the message where it was sent, to the room, to everyone or
+// to the other client of a direct message.
+func (c *Client) Reply(m msg.MSG, data string) error {
+	recipient := m.Recipient
+	if recipient != "" && !msg.IsRoom(recipient) && m.Sender != c.Config.Name {
+		recipient = m.Sender
+	}
+
+	return c.Send(msg.MSG{ID: msg.NewID(), ReplyTo: m.ID, Recipient: recipient, Type: msg.Message, Data: data})
+}
+
+// Typing tells the room or the client the user is writing to them. chatd
+// throttles the notices, send one every few seconds while writing.
+func (c *Client) Typing(recipient string) error {
+	return c.Send(msg.MSG{Recipient: recipient, Type: msg.Typing})
+}
+
+// MarkRead tells the sender of a direct message the client read it.
+func (c *Client) MarkRead(id string) error {
+	return c.Send(msg.MSG{ID: id, Type: msg.ReadReceipt})
+}
+
+// Search searches the history of the conversations the client belongs to for
+// the words of the query, only the room or the direct messages with the
+// client in where when it is set. The messages found are handled with
+// On(msg.Search), followed by one without an ID holding the number found.
+func (c *Client) Search(where string, query string) error {
+	return c.Send(msg.MSG{Recipient: where, Type: msg.Search, Data: query})
+}
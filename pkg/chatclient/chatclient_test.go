@@ -0,0 +1,216 @@
+package chatclient_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"chat/pkg/chatclient"
+	"chat/pkg/msg"
+)
+
+const succeed = "\u2713"
+const failed = "\u2717"
+
+// server represents a fake chatd.
+type server struct {
+	t     *testing.T
+	l     net.Listener
+	conns chan net.Conn
+	recv  chan msg.MSG
+}
+
+// startServer listens on a random port and reports the connections and
+// the messages received.
+func startServer(t *testing.T) *server {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Should be able to listen : %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := server{
+		t:     t,
+		l:     l,
+		conns: make(chan net.Conn, 10),
+		recv:  make(chan msg.MSG, 100),
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.conns <- conn
+
+			go func() {
+				for {
+					data, _, err := msg.Read(conn)
+					if err != nil {
+						return
+					}
+					m, err := msg.Decode(data)
+					if err != nil {
+						return
+					}
+					s.recv <- m
+				}
+			}()
+		}
+	}()
+
+	return &s
+}
+
+// accept waits for the next connection.
+func (s *server) accept() net.Conn {
+	s.t.Helper()
+
+	select {
+	case conn := <-s.conns:
+		return conn
+	case <-time.After(2 * time.Second):
+		s.t.Fatalf("\t%s\tShould receive a connection.", failed)
+	}
+	return nil
+}
+
+// expect waits for the next message received.
+func (s *server) expect(typ uint8) msg.MSG {
+	s.t.Helper()
+
+	select {
+	case m := <-s.recv:
+		if m.Type != typ {
+			s.t.Fatalf("\t%s\tShould receive type %d : got%v", failed, typ, m)
+		}
+		return m
+	case <-time.After(2 * time.Second):
+		s.t.Fatalf("\t%s\tShould receive type %d.", failed, typ)
+	}
+	return msg.MSG{}
+}
+
+// send writes the message to the connection.
+func send(t *testing.T, conn net.Conn, m msg.MSG) {
+	t.Helper()
+
+	if _, err := conn.Write(msg.Encode(m)); err != nil {
+		t.Fatalf("Should be able to send : %v", err)
+	}
+}
+
+// TestClient test that the client logs in, sends and handles messages.
+func TestClient(t *testing.T) {
+	s := startServer(t)
+
+	cfg := chatclient.Config{
+		Host:      s.l.Addr().String(),
+		Name:      "bot",
+		Reconnect: 10 * time.Millisecond,
+	}
+	c := chatclient.New(cfg)
+	defer c.Close()
+
+	received := make(chan msg.MSG, 10)
+	c.OnMessage(func(m msg.MSG) { received <- m })
+
+	var conn net.Conn
+
+	t.Log("Given the need to write chat bots.")
+	{
+		t.Logf("\tTest 0:\tWhen connecting")
+		{
+			if err := c.Connect(); err != nil {
+				t.Fatalf("\t%s\tShould be able to connect : %v", failed, err)
+			}
+			conn = s.accept()
+
+			if m := s.expect(msg.Init); m.Sender != "bot" {
+				t.Fatalf("\t%s\tShould login : got%v", failed, m)
+			}
+			t.Logf("\t%s\tShould login.", succeed)
+
+			send(t, conn, msg.MSG{Type: msg.Ping})
+			s.expect(msg.Pong)
+			t.Logf("\t%s\tShould answer pings.", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen chatting")
+		{
+			c.DM("bill", "hi bill")
+			if m := s.expect(msg.Message); m.Sender != "bot" || m.Recipient != "bill" || m.Data != "hi bill" {
+				t.Fatalf("\t%s\tShould send direct messages : got%v", failed, m)
+			}
+			t.Logf("\t%s\tShould send direct messages.", succeed)
+
+			c.Join("#dev")
+			if m := s.expect(msg.JoinRoom); m.Recipient != "#dev" {
+				t.Fatalf("\t%s\tShould join rooms : got%v", failed, m)
+			}
+			t.Logf("\t%s\tShould join rooms.", succeed)
+
+			send(t, conn, msg.MSG{Sender: "bill", Type: msg.Message, Data: "hello"})
+			select {
+			case m := <-received:
+				if m.Sender != "bill" || m.Data != "hello" {
+					t.Fatalf("\t%s\tShould call the message handlers : got%v", failed, m)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("\t%s\tShould call the message handlers.", failed)
+			}
+			t.Logf("\t%s\tShould call the message handlers.", succeed)
+
+			c.Reply(msg.MSG{ID: "a1", Sender: "bill", Recipient: "bot", Type: msg.Message}, "hi")
+			if m := s.expect(msg.Message); m.Recipient != "bill" || m.ReplyTo != "a1" || m.ID == "" {
+				t.Fatalf("\t%s\tShould reply to the sender of a direct message : got%v", failed, m)
+			}
+			t.Logf("\t%s\tShould reply to the sender of a direct message.", succeed)
+		}
+
+		t.Logf("\tTest 2:\tWhen the connection is lost")
+		{
+			conn.Close()
+			s.accept()
+
+			s.expect(msg.Init)
+			if m := s.expect(msg.JoinRoom); m.Recipient != "#dev" {
+				t.Fatalf("\t%s\tShould join the rooms again : got%v", failed, m)
+			}
+			t.Logf("\t%s\tShould reconnect and join the rooms again.", succeed)
+		}
+	}
+}
+
+// TestNameTaken test that the client stops when the name is taken.
+func TestNameTaken(t *testing.T) {
+	s := startServer(t)
+
+	c := chatclient.New(chatclient.Config{Host: s.l.Addr().String(), Name: "bill"})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Should be able to connect : %v", err)
+	}
+	conn := s.accept()
+	s.expect(msg.Init)
+
+	t.Log("Given the need to login with a name already connected.")
+	{
+		t.Logf("\tTest 0:\tWhen chatd answers the name is in cache")
+		{
+			send(t, conn, msg.MSG{Sender: "bill", Recipient: "bill", Type: msg.InCache, Data: "127.0.0.1:1"})
+
+			select {
+			case <-c.Done():
+			case <-time.After(2 * time.Second):
+				t.Fatalf("\t%s\tShould stop the client.", failed)
+			}
+			t.Logf("\t%s\tShould stop the client.", succeed)
+
+			if err := c.Err(); err != chatclient.ErrNameTaken {
+				t.Fatalf("\t%s\tShould report the name is taken : got %v", failed, err)
+			}
+			t.Logf("\t%s\tShould report the name is taken.", succeed)
+		}
+	}
+}
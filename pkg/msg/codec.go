@@ -0,0 +1,165 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Codec defines how messages are framed on the wire.
+type Codec interface {
+	Read(r io.Reader) (MSG, error)
+	Encode(m MSG) []byte
+}
+
+// Binary is the codec for the fixed 24 byte header protocol.
+type Binary struct{}
+
+// Read waits on the network to receive a chat message.
+func (Binary) Read(r io.Reader) (MSG, error) {
+	data, _, err := Read(r)
+	if err != nil {
+		return MSG{}, err
+	}
+	return Decode(data)
+}
+
+// Encode will take a message and produce byte slice.
+func (Binary) Encode(m MSG) []byte {
+	return Encode(m)
+}
+
+// =============================================================================
+
+// maxLineLength is the longest line the JSON codec accepts, large enough
+// for the biggest message once escaped.
+const maxLineLength = 8 * MaxDataLength
+
+// ErrLineLength is returned when a JSON line is longer than allowed.
+var ErrLineLength = errors.New("line too long")
+
+// jsonMSG is the JSON representation of a message.
+type jsonMSG struct {
+	ID        string `json:"id,omitempty"`
+	ReplyTo   string `json:"reply_to,omitempty"`
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient,omitempty"`
+	Type      string `json:"type"`
+	Data      string `json:"data,omitempty"`
+}
+
+// JSON is the codec for messages written as one JSON object per line:
+//
+//	{"sender":"bill","recipient":"jill","type":"message","data":"hello"}
+type JSON struct{}
+
+// Read waits on the network to receive a line holding a chat message.
+// Wrap the reader in a bufio.Reader, otherwise the line is read one byte
+// at a time.
+func (JSON) Read(r io.Reader) (MSG, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = byteReader{r}
+	}
+
+	var line []byte
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return MSG{}, errors.Wrap(err, "reading line")
+		}
+
+		if b == '\n' {
+			break
+		}
+
+		if len(line) == maxLineLength {
+			return MSG{}, ErrLineLength
+		}
+		line = append(line, b)
+	}
+
+	return DecodeJSON(bytes.TrimSpace(line))
+}
+
+// Encode will take a message and produce a JSON line.
+func (JSON) Encode(m MSG) []byte {
+	jm := jsonMSG{
+		ID:        m.ID,
+		ReplyTo:   m.ReplyTo,
+		Sender:    m.Sender,
+		Recipient: m.Recipient,
+		Type:      TypeName(m.Type),
+		Data:      m.Data,
+	}
+
+	// Marshaling a struct of strings can't fail.
+	data, _ := json.Marshal(jm)
+	return append(data, '\n')
+}
+
+// DecodeJSON will take a JSON object and create a MSG value. The fields
+// are held to the limits of the binary protocol.
+func DecodeJSON(data []byte) (MSG, error) {
+	var jm jsonMSG
+	if err := json.Unmarshal(data, &jm); err != nil {
+		return MSG{}, errors.Wrap(err, "decoding JSON")
+	}
+
+	typ, err := ParseType(jm.Type)
+	if err != nil {
+		return MSG{}, err
+	}
+
+	if len(jm.Sender) > 10 || len(jm.Recipient) > 10 {
+		return MSG{}, ErrNameLength
+	}
+
+	ni := len(jm.ID)
+	if jm.ReplyTo != "" {
+		ni += 1 + len(jm.ReplyTo)
+	}
+
+	if ni > MaxIDLength {
+		return MSG{}, ErrIDLength
+	}
+
+	if strings.Contains(jm.ID, " ") || strings.Contains(jm.ReplyTo, " ") {
+		return MSG{}, ErrIDs
+	}
+
+	if ni+len(jm.Data) > MaxDataLength {
+		return MSG{}, ErrLength
+	}
+
+	m := MSG{
+		ID:        jm.ID,
+		ReplyTo:   jm.ReplyTo,
+		Sender:    jm.Sender,
+		Recipient: jm.Recipient,
+		Type:      typ,
+		Data:      jm.Data,
+	}
+
+	return m, nil
+}
+
+// byteReader reads one byte at a time from a reader.
+type byteReader struct {
+	io.Reader
+}
+
+// ReadByte implements the io.ByteReader interface.
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r.Reader, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
@@ -0,0 +1,92 @@
+package msg_test
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"chat/pkg/msg"
+
+	"github.com/pkg/errors"
+)
+
+// TestCodecs test that every codec round trips messages.
+func TestCodecs(t *testing.T) {
+	codecs := []struct {
+		name  string
+		codec msg.Codec
+	}{
+		{name: "binary", codec: msg.Binary{}},
+		{name: "json", codec: msg.JSON{}},
+	}
+
+	m := msg.MSG{
+		ID:        msg.NewID(),
+		ReplyTo:   msg.NewID(),
+		Sender:    "bill",
+		Recipient: "#go",
+		Type:      msg.Message,
+		Data:      "hello \"world\"\nbye",
+	}
+
+	t.Log("Given the need to test the codecs.")
+	{
+		for i, tst := range codecs {
+			t.Logf("\tTest %d:\t%s", i, tst.name)
+			{
+				var stream bytes.Buffer
+				stream.Write(tst.codec.Encode(m))
+				stream.Write(tst.codec.Encode(m))
+
+				r := bufio.NewReader(&stream)
+				for j := 0; j < 2; j++ {
+					got, err := tst.codec.Read(r)
+					if err != nil {
+						t.Fatalf("\t%s\tShould be able to read message %d : %v\n", failed, j, err)
+					}
+					if got != m {
+						t.Fatalf("\t%s\tShould get the same message back : exp%v got%v\n", failed, m, got)
+					}
+				}
+				t.Logf("\t%s\tShould get the same messages back.\n", succeed)
+			}
+		}
+	}
+}
+
+// TestDecodeJSON test that invalid JSON messages are rejected.
+func TestDecodeJSON(t *testing.T) {
+	tt := []struct {
+		name string
+		line string
+		err  error
+	}{
+		{name: "type", line: `{"sender":"bill","type":"shout"}`, err: msg.ErrType},
+		{name: "sender", line: `{"sender":"BillKennedy","type":"message"}`, err: msg.ErrNameLength},
+		{name: "id", line: `{"id":"` + strings.Repeat("x", msg.MaxIDLength+1) + `","sender":"bill","type":"message"}`, err: msg.ErrIDLength},
+		{name: "ids", line: `{"id":"a1 b2","sender":"bill","type":"message"}`, err: msg.ErrIDs},
+		{name: "data", line: `{"sender":"bill","type":"message","data":"` + strings.Repeat("x", msg.MaxDataLength+1) + `"}`, err: msg.ErrLength},
+	}
+
+	t.Log("Given the need to reject invalid JSON messages.")
+	{
+		for i, tst := range tt {
+			t.Logf("\tTest %d:\t%s", i, tst.name)
+			{
+				if _, err := msg.DecodeJSON([]byte(tst.line)); errors.Cause(err) != tst.err {
+					t.Fatalf("\t%s\tShould fail with the right error : exp[%v] got[%v]\n", failed, tst.err, err)
+				}
+				t.Logf("\t%s\tShould fail with the right error.\n", succeed)
+			}
+		}
+
+		t.Logf("\tTest %d:\tnot json", len(tt))
+		{
+			if _, err := msg.DecodeJSON([]byte("hello")); err == nil {
+				t.Fatalf("\t%s\tShould fail on a line that is not JSON.\n", failed)
+			}
+			t.Logf("\t%s\tShould fail on a line that is not JSON.\n", succeed)
+		}
+	}
+}
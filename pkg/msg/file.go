@@ -0,0 +1,106 @@
+package msg
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ChunkSize is the size of the file parts sent in each FileChunk message.
+// Once base64 encoded they fit in the data of a message.
+const ChunkSize = 32 * 1024
+
+// ErrFileData is returned when the data of a file message is malformed.
+var ErrFileData = errors.New("malformed file message")
+
+// Offer describes the file a client wants to send. It is carried in
+// the data of FileOffer messages as "id size checksum name".
+type Offer struct {
+	ID       string // Picked by the sender to tell its transfers apart.
+	Size     int64
+	Checksum string // Hex SHA-256 of the file.
+	Name     string
+}
+
+// String encodes the offer for the data of a message.
+func (o Offer) String() string {
+	return o.ID + " " + strconv.FormatInt(o.Size, 10) + " " + o.Checksum + " " + o.Name
+}
+
+// ParseOffer decodes the data of a FileOffer message.
+func ParseOffer(data string) (Offer, error) {
+	f := strings.SplitN(data, " ", 4)
+	if len(f) != 4 || f[0] == "" || f[2] == "" || f[3] == "" {
+		return Offer{}, ErrFileData
+	}
+
+	size, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil || size < 0 {
+		return Offer{}, ErrFileData
+	}
+
+	o := Offer{
+		ID:       f[0],
+		Size:     size,
+		Checksum: f[2],
+		Name:     f[3],
+	}
+
+	return o, nil
+}
+
+// Chunk is a part of a file. It is carried in the data of FileChunk
+// messages as "id seq base64(data)".
+type Chunk struct {
+	ID   string
+	Seq  int // Position of the chunk, starting at 0.
+	Data []byte
+}
+
+// String encodes the chunk for the data of a message.
+func (c Chunk) String() string {
+	return c.ID + " " + strconv.Itoa(c.Seq) + " " + base64.StdEncoding.EncodeToString(c.Data)
+}
+
+// ParseChunk decodes the data of a FileChunk message.
+func ParseChunk(data string) (Chunk, error) {
+	f := strings.SplitN(data, " ", 3)
+	if len(f) != 3 || f[0] == "" {
+		return Chunk{}, ErrFileData
+	}
+
+	seq, err := strconv.Atoi(f[1])
+	if err != nil || seq < 0 {
+		return Chunk{}, ErrFileData
+	}
+
+	b, err := base64.StdEncoding.DecodeString(f[2])
+	if err != nil {
+		return Chunk{}, ErrFileData
+	}
+
+	c := Chunk{
+		ID:   f[0],
+		Seq:  seq,
+		Data: b,
+	}
+
+	return c, nil
+}
+
+// FileReply returns the data of FileAccept and FileReject messages, the
+// id of the transfer and an optional reason.
+func FileReply(id string, reason string) string {
+	if reason == "" {
+		return id
+	}
+	return id + " " + reason
+}
+
+// ParseFileReply decodes the data of FileAccept and FileReject messages.
+func ParseFileReply(data string) (string, string) {
+	id, reason, _ := strings.Cut(data, " ")
+	return id, reason
+}
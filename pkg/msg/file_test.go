@@ -0,0 +1,59 @@
+package msg_test
+
+import (
+	"bytes"
+	"testing"
+
+	"chat/pkg/msg"
+)
+
+// TestFile test the encoding of the file transfer messages.
+func TestFile(t *testing.T) {
+	t.Log("Given the need to transfer files.")
+	{
+		t.Logf("\tTest 0:\tWhen encoding and decoding")
+		{
+			o := msg.Offer{ID: "a1b2", Size: 42, Checksum: "abcd", Name: "my notes.txt"}
+			got, err := msg.ParseOffer(o.String())
+			if err != nil || got != o {
+				t.Fatalf("\t%s\tShould round trip offers : got %+v : %v", failed, got, err)
+			}
+			t.Logf("\t%s\tShould round trip offers.", succeed)
+
+			c := msg.Chunk{ID: "a1b2", Seq: 3, Data: []byte{0, 1, 2, 255}}
+			gotc, err := msg.ParseChunk(c.String())
+			if err != nil || gotc.ID != c.ID || gotc.Seq != c.Seq || !bytes.Equal(gotc.Data, c.Data) {
+				t.Fatalf("\t%s\tShould round trip chunks : got %+v : %v", failed, gotc, err)
+			}
+			t.Logf("\t%s\tShould round trip chunks.", succeed)
+
+			if len(msg.Chunk{ID: "a1b2c3d4", Seq: 1 << 20, Data: make([]byte, msg.ChunkSize)}.String()) > msg.MaxDataLength {
+				t.Fatalf("\t%s\tShould fit a full chunk in a message.", failed)
+			}
+			t.Logf("\t%s\tShould fit a full chunk in a message.", succeed)
+
+			id, reason := msg.ParseFileReply(msg.FileReply("a1b2", "too large"))
+			if id != "a1b2" || reason != "too large" {
+				t.Fatalf("\t%s\tShould round trip replies : got %s %s", failed, id, reason)
+			}
+			t.Logf("\t%s\tShould round trip replies.", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen decoding malformed data")
+		{
+			for _, data := range []string{"", "a1b2", "a1b2 -1 abcd name", "a1b2 big abcd name", "a1b2 42 abcd"} {
+				if _, err := msg.ParseOffer(data); err != msg.ErrFileData {
+					t.Fatalf("\t%s\tShould refuse the offer [ %s ] : got %v", failed, data, err)
+				}
+			}
+			t.Logf("\t%s\tShould refuse malformed offers.", succeed)
+
+			for _, data := range []string{"", "a1b2 0", "a1b2 x AAAA", "a1b2 0 not-base64!"} {
+				if _, err := msg.ParseChunk(data); err != msg.ErrFileData {
+					t.Fatalf("\t%s\tShould refuse the chunk [ %s ] : got %v", failed, data, err)
+				}
+			}
+			t.Logf("\t%s\tShould refuse malformed chunks.", succeed)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package msg_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"chat/pkg/msg"
+)
+
+// FuzzDecode makes sure decoding arbitrary bytes never panics and that
+// every frame that decodes encodes back to the same bytes.
+func FuzzDecode(f *testing.F) {
+	f.Add(msg.Encode(msg.MSG{Sender: "bill", Recipient: "jill", Type: msg.Message, Data: "hello"}))
+	f.Add(msg.Encode(msg.MSG{Sender: "BillKenned", Recipient: "#go", Type: msg.JoinRoom}))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := msg.Decode(data)
+		if err != nil {
+			return
+		}
+
+		// Names stop at the first zero byte, so only compare up to there.
+		got := msg.Encode(m)
+		if len(got) != len(data) || !bytes.Equal(got[20:], data[20:]) {
+			t.Fatalf("round trip mismatch : exp[%x] got[%x]", data, got)
+		}
+	})
+}
+
+// FuzzRead makes sure reading arbitrary streams never panics and that
+// every frame read decodes.
+func FuzzRead(f *testing.F) {
+	f.Add(msg.Encode(msg.MSG{Sender: "bill", Type: msg.Message, Data: "hello"}))
+	f.Add([]byte{0, 1, 2})
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		data, n, err := msg.Read(bytes.NewReader(stream))
+		if err != nil {
+			return
+		}
+
+		if n != len(data) {
+			t.Fatalf("length mismatch : exp[%d] got[%d]", len(data), n)
+		}
+
+		if _, err := msg.Decode(data); err != nil {
+			t.Fatalf("frame read should decode : %v", err)
+		}
+	})
+}
+
+// FuzzEncode makes sure every valid message survives an encode/decode
+// round trip.
+func FuzzEncode(f *testing.F) {
+	f.Add("bill", "jill", msg.Message, "hello")
+	f.Add("BillKennedy", "#go", msg.JoinRoom, "")
+
+	f.Fuzz(func(t *testing.T, sender string, recipient string, typ uint8, data string) {
+		if typ > msg.LeaveRoom {
+			return
+		}
+
+		m, err := msg.Decode(msg.Encode(msg.MSG{Sender: sender, Recipient: recipient, Type: typ, Data: data}))
+		if err != nil {
+			t.Fatalf("encoded message should decode : %v", err)
+		}
+
+		if exp := name(sender); m.Sender != exp {
+			t.Fatalf("sender mismatch : exp[%q] got[%q]", exp, m.Sender)
+		}
+		if exp := name(recipient); m.Recipient != exp {
+			t.Fatalf("recipient mismatch : exp[%q] got[%q]", exp, m.Recipient)
+		}
+		if m.Type != typ {
+			t.Fatalf("type mismatch : exp[%d] got[%d]", typ, m.Type)
+		}
+		if len(data) <= msg.MaxDataLength && m.Data != data {
+			t.Fatalf("data mismatch : exp[%q] got[%q]", data, m.Data)
+		}
+	})
+}
+
+// name returns what is left of a sender or recipient after encoding.
+func name(s string) string {
+	if len(s) > 10 {
+		s = s[:10]
+	}
+	if i := strings.IndexByte(s, 0); i != -1 {
+		s = s[:i]
+	}
+	return s
+}
@@ -0,0 +1,319 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+const hdrLength = 24
+
+// MaxDataLength is the largest data a message can carry since its length
+// is stored in two bytes of the header. The id of the message counts
+// against it.
+const MaxDataLength = 1<<16 - 1
+
+// MaxIDLength is the longest ids a message can carry since their length is
+// stored in the last byte of the header. The id of the message and the id
+// of the message it replies to are separated by a space.
+const MaxIDLength = 1<<8 - 1
+
+// Set of errors returned when validating a message.
+var (
+	ErrShortFrame = errors.New("frame shorter than the header")
+	ErrLength     = errors.New("data length does not match the header")
+	ErrType       = errors.New("unknown message type")
+	ErrIDLength   = errors.New("id longer than the data")
+	ErrIDs        = errors.New("ids hold a space or an empty reply")
+	ErrNameLength = errors.New("sender or recipient longer than 10 bytes")
+)
+
+// Set of message types.
+const (
+	Init = uint8(iota)
+	Message
+	InCache
+	Ping      // Sent by the server to check an idle connection.
+	Pong      // Sent by the client in response to a Ping.
+	Join      // Sent by the server when a client comes online.
+	Leave     // Sent by the server when a client goes offline.
+	JoinRoom  // Sent when a client joins the room in Recipient.
+	LeaveRoom // Sent when a client leaves the room in Recipient.
+
+	FileOffer  // Sent to offer a file to the client in Recipient, see Offer.
+	FileAccept // Sent to accept a file offer, Data holds the id of the transfer.
+	FileReject // Sent to decline a file offer or by the server to refuse it, see FileReply.
+	FileChunk  // Sent with a part of the file once accepted, see Chunk.
+
+	Edit        // Sent to replace the data of the message with the ID.
+	Delete      // Sent to delete the message with the ID.
+	React       // Sent to react to the message with the ID, see Reaction.
+	Typing      // Sent while writing a message to the room or client in Recipient.
+	ReadReceipt // Sent by the recipient of the direct message with the ID once read.
+	Search      // Sent to search the history for the words in Data, answered with each message found.
+
+	numTypes // Number of message types, keep last.
+)
+
+// typeNames holds the names of the message types used by the text codecs.
+var typeNames = [numTypes]string{
+	Init:      "init",
+	Message:   "message",
+	InCache:   "incache",
+	Ping:      "ping",
+	Pong:      "pong",
+	Join:      "join",
+	Leave:     "leave",
+	JoinRoom:  "joinroom",
+	LeaveRoom: "leaveroom",
+
+	FileOffer:  "fileoffer",
+	FileAccept: "fileaccept",
+	FileReject: "filereject",
+	FileChunk:  "filechunk",
+
+	Edit:        "edit",
+	Delete:      "delete",
+	React:       "react",
+	Typing:      "typing",
+	ReadReceipt: "read",
+	Search:      "search",
+}
+
+// TypeName returns the name of the message type.
+func TypeName(typ uint8) string {
+	if typ >= numTypes {
+		return "unknown"
+	}
+	return typeNames[typ]
+}
+
+// ParseType returns the message type for the specified name.
+func ParseType(name string) (uint8, error) {
+	for typ, n := range typeNames {
+		if n == name {
+			return uint8(typ), nil
+		}
+	}
+	return 0, errors.Wrapf(ErrType, "type[%s]", name)
+}
+
+// RoomPrefix marks a recipient as a room rather than a client. Since the
+// recipient is limited to 10 bytes, room names can be up to 9 characters.
+const RoomPrefix = "#"
+
+// IsRoom reports whether the recipient names a room.
+func IsRoom(recipient string) bool {
+	return strings.HasPrefix(recipient, RoomPrefix)
+}
+
+// NewID returns a random id for a message, a version 4 UUID so ids picked
+// by different clients don't collide.
+func NewID() string {
+	return uuid.NewV4().String()
+}
+
+// MSG defines the message protocol data.
+type MSG struct {
+	ID        string // Assigned to the chat messages, edits and deletes refer to it.
+	ReplyTo   string // Id of the message a chat message replies to.
+	Sender    string
+	Recipient string
+	Type      uint8
+	Data      string
+}
+
+// String implements the fmt.Stringer interface.
+func (m MSG) String() string {
+	var b bytes.Buffer
+
+	b.WriteString("\n{\n")
+	if m.ID != "" {
+		b.WriteString(fmt.Sprintf("\tID: %s\n", m.ID))
+	}
+	if m.ReplyTo != "" {
+		b.WriteString(fmt.Sprintf("\tReplyTo: %s\n", m.ReplyTo))
+	}
+	b.WriteString(fmt.Sprintf("\tSender: %s\n", m.Sender))
+	b.WriteString(fmt.Sprintf("\tRecipient: %s\n", m.Recipient))
+	b.WriteString(fmt.Sprintf("\tType: %d\n", m.Type))
+	b.WriteString(fmt.Sprintf("\tData: %s\n}", m.Data))
+
+	return b.String()
+}
+
+// Read waits on the network to receive a chat message. The header is
+// validated before the data is read, so a malformed frame is reported
+// without reading an arbitrary amount of data.
+func Read(r io.Reader) ([]byte, int, error) {
+
+	// Read the first header length of bytes.
+	buf := make([]byte, hdrLength)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, 0, errors.Wrap(err, "ReadFull header")
+	}
+
+	if err := validateHeader(buf); err != nil {
+		return nil, 0, err
+	}
+
+	// Get the length for the remaining bytes.
+	length := int(binary.BigEndian.Uint16(buf[20:22])) + hdrLength
+
+	// Copy the header bytes into the final slice.
+	data := make([]byte, length)
+	copy(data, buf)
+
+	// Read the remaining bytes.
+	if _, err := io.ReadFull(r, data[hdrLength:]); err != nil {
+		return nil, 0, errors.Wrap(err, "ReadFull data")
+	}
+
+	return data, length, nil
+}
+
+// Decode will take the bytes and create a MSG value.
+func Decode(data []byte) (MSG, error) {
+	if len(data) < hdrLength {
+		return MSG{}, ErrShortFrame
+	}
+
+	if err := validateHeader(data); err != nil {
+		return MSG{}, err
+	}
+
+	if n := int(binary.BigEndian.Uint16(data[20:22])); n != len(data)-hdrLength {
+		return MSG{}, errors.Wrapf(ErrLength, "exp[%d] got[%d]", n, len(data)-hdrLength)
+	}
+
+	// Extract the bytes for the sender.
+	var sender string
+	if n := bytes.IndexByte(data[:10], 0); n != -1 {
+		sender = string(data[:n])
+	} else {
+		sender = string(data[:10])
+	}
+
+	// Extract the bytes for the recipient.
+	var recipient string
+	if n := bytes.IndexByte(data[10:20], 0); n != -1 {
+		recipient = string(data[10 : 10+n])
+	} else {
+		recipient = string(data[10:20])
+	}
+
+	// The ids come first in the data.
+	nid := hdrLength + int(data[23])
+	id, replyTo, reply := strings.Cut(string(data[hdrLength:nid]), " ")
+	if reply && replyTo == "" {
+		return MSG{}, ErrIDs
+	}
+
+	// Return the full message.
+	m := MSG{
+		ID:        id,
+		ReplyTo:   replyTo,
+		Sender:    sender,
+		Recipient: recipient,
+		Type:      data[22],
+		Data:      string(data[nid:]),
+	}
+
+	return m, nil
+}
+
+// validateHeader checks the fields of the header that don't depend on the
+// data following it.
+func validateHeader(hdr []byte) error {
+	if hdr[22] >= numTypes {
+		return errors.Wrapf(ErrType, "type[%d]", hdr[22])
+	}
+
+	if int(hdr[23]) > int(binary.BigEndian.Uint16(hdr[20:22])) {
+		return errors.Wrapf(ErrIDLength, "id[%d] data[%d]", hdr[23], binary.BigEndian.Uint16(hdr[20:22]))
+	}
+
+	return nil
+}
+
+// Encode will take a message and produce byte slice.
+func Encode(m MSG) []byte {
+
+	// We can't have more than the first 10 bytes.
+	ns := len(m.Sender)
+	if ns > 10 {
+		ns = 10
+	}
+
+	nr := len(m.Recipient)
+	if nr > 10 {
+		nr = 10
+	}
+
+	// Nor more data than the header can describe.
+	ids := m.ID
+	if m.ReplyTo != "" {
+		ids += " " + m.ReplyTo
+	}
+
+	ni := len(ids)
+	if ni > MaxIDLength {
+		ni = MaxIDLength
+	}
+
+	nd := len(m.Data)
+	if nd > MaxDataLength-ni {
+		nd = MaxDataLength - ni
+	}
+
+	// Create a slice of the exact length we need.
+	data := make([]byte, hdrLength+ni+nd)
+
+	// Copy the bytes into the slice for our protocol.
+
+	copy(data, m.Sender[:ns])
+	copy(data[10:], m.Recipient[:nr])
+	binary.BigEndian.PutUint16(data[20:22], uint16(ni+nd))
+	data[22] = m.Type
+	data[23] = byte(ni)
+	copy(data[hdrLength:], ids[:ni])
+	copy(data[hdrLength+ni:], m.Data[:nd])
+
+	return data
+}
+
+// Gets Recipient from message
+func GetRecipient(m string) string {
+	// If the message starts with @ then we have a recipient.
+	if strings.HasPrefix(m, "@") {
+		data := strings.Fields(m)
+		recipient := strings.TrimPrefix(data[0], "@")
+		return recipient
+	}
+
+	// If the message starts with # then the recipient is a room.
+	if IsRoom(m) {
+		return strings.Fields(m)[0]
+	}
+
+	return ""
+}
+
+// Gets data from message
+func GetData(m string) string {
+	// If the message starts with @ or # then we have a recipient.
+	if strings.HasPrefix(m, "@") || IsRoom(m) {
+		data := strings.Fields(m)
+		if len(data) > 1 {
+			return strings.Join(data[1:], " ")
+		}
+		return ""
+	}
+
+	return m
+}
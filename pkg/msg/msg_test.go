@@ -3,7 +3,9 @@ package msg_test
 import (
 	"testing"
 
-	"chat/internal/msg"
+	"chat/pkg/msg"
+
+	"github.com/pkg/errors"
 )
 
 const succeed = "\u2713"
@@ -36,6 +38,29 @@ func TestEncode(t *testing.T) {
 			},
 			length: 34,
 		},
+		{
+			name: "id",
+			m: msg.MSG{
+				ID:        "a1b2c3d4",
+				Sender:    "Bill",
+				Recipient: "#go",
+				Type:      msg.Edit,
+				Data:      "hello",
+			},
+			length: 37,
+		},
+		{
+			name: "reply",
+			m: msg.MSG{
+				ID:        "a1b2c3d4",
+				ReplyTo:   "e5f6a7b8",
+				Sender:    "Bill",
+				Recipient: "#go",
+				Type:      msg.Message,
+				Data:      "hello",
+			},
+			length: 46,
+		},
 	}
 
 	t.Log("Given the need to test encoding/decoding.")
@@ -49,7 +74,22 @@ func TestEncode(t *testing.T) {
 				}
 				t.Logf("\t%s\tShould have the correct number of bytes.\n", succeed)
 
-				m := msg.Decode(data)
+				m, err := msg.Decode(data)
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to decode the message : %v\n", failed, err)
+				}
+				t.Logf("\t%s\tShould be able to decode the message.\n", succeed)
+
+				if m.ID != tst.m.ID {
+					t.Fatalf("\t%s\tShould have the correct ID : exp[%v] got[%v]\n", failed, tst.m.ID, m.ID)
+				}
+				t.Logf("\t%s\tShould have the correct ID.\n", succeed)
+
+				if m.ReplyTo != tst.m.ReplyTo {
+					t.Fatalf("\t%s\tShould have the correct ReplyTo : exp[%v] got[%v]\n", failed, tst.m.ReplyTo, m.ReplyTo)
+				}
+				t.Logf("\t%s\tShould have the correct ReplyTo.\n", succeed)
+
 				if m.Sender != tst.m.Sender {
 					t.Fatalf("\t%s\tShould have the correct Sender : exp[%v] got[%v]\n", failed, tst.m.Sender, m.Sender)
 				}
@@ -74,6 +114,47 @@ func TestEncode(t *testing.T) {
 	}
 }
 
+// TestDecodeMalformed test that malformed frames are rejected.
+func TestDecodeMalformed(t *testing.T) {
+	valid := msg.Encode(msg.MSG{Sender: "bill", Type: msg.Message, Data: "hello"})
+
+	badType := append([]byte(nil), valid...)
+	badType[22] = 255
+
+	badID := append([]byte(nil), valid...)
+	badID[23] = 6
+
+	badReply := msg.Encode(msg.MSG{ID: "a1", Sender: "bill", Type: msg.Message, Data: " hello"})
+	badReply[23] = 3
+
+	tt := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "empty", data: nil, err: msg.ErrShortFrame},
+		{name: "short", data: valid[:10], err: msg.ErrShortFrame},
+		{name: "truncated", data: valid[:len(valid)-1], err: msg.ErrLength},
+		{name: "trailing", data: append(append([]byte(nil), valid...), 'x'), err: msg.ErrLength},
+		{name: "type", data: badType, err: msg.ErrType},
+		{name: "id", data: badID, err: msg.ErrIDLength},
+		{name: "reply", data: badReply, err: msg.ErrIDs},
+	}
+
+	t.Log("Given the need to reject malformed frames.")
+	{
+		for i, tst := range tt {
+			t.Logf("\tTest %d:\t%s", i, tst.name)
+			{
+				if _, err := msg.Decode(tst.data); errors.Cause(err) != tst.err {
+					t.Fatalf("\t%s\tShould fail with the right error : exp[%v] got[%v]\n", failed, tst.err, err)
+				}
+				t.Logf("\t%s\tShould fail with the right error.\n", succeed)
+			}
+		}
+	}
+}
+
 func TestGetRecipient(t *testing.T) {
 	tt := []struct {
 		Data      string
@@ -91,6 +172,10 @@ func TestGetRecipient(t *testing.T) {
 			Data:      "@billhello",
 			Recipient: "billhello",
 		},
+		{
+			Data:      "#go hello",
+			Recipient: "#go",
+		},
 		{
 			Data:      "hello",
 			Recipient: "",
@@ -133,6 +218,10 @@ func TestGetData(t *testing.T) {
 			Data: "@billhello",
 			Msg:  "",
 		},
+		{
+			Data: "#go hello world",
+			Msg:  "hello world",
+		},
 		{
 			Data: "hello",
 			Msg:  "hello",
@@ -0,0 +1,48 @@
+package msg
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MaxEmojiLength is the longest emoji a reaction can carry.
+const MaxEmojiLength = 32
+
+// ErrReaction is returned when the data of a React message is malformed.
+var ErrReaction = errors.New("malformed reaction")
+
+// Reaction is carried in the data of React messages. Clients send the
+// emoji alone, reacting twice with the same emoji takes the reaction back.
+// The server forwards it as "emoji count", with the number of reactions
+// with the emoji the message has once applied.
+type Reaction struct {
+	Emoji string
+	Count int
+}
+
+// String encodes the reaction for the data of a message.
+func (r Reaction) String() string {
+	return r.Emoji + " " + strconv.Itoa(r.Count)
+}
+
+// ParseReaction decodes the data of a React message, the count is zero
+// when missing.
+func ParseReaction(data string) (Reaction, error) {
+	emoji, count, counted := strings.Cut(data, " ")
+	if emoji == "" || len(emoji) > MaxEmojiLength {
+		return Reaction{}, ErrReaction
+	}
+
+	r := Reaction{Emoji: emoji}
+	if counted {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return Reaction{}, ErrReaction
+		}
+		r.Count = n
+	}
+
+	return r, nil
+}
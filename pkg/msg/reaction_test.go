@@ -0,0 +1,39 @@
+package msg_test
+
+import (
+	"strings"
+	"testing"
+
+	"chat/pkg/msg"
+)
+
+// TestReaction test the encoding of the reactions.
+func TestReaction(t *testing.T) {
+	t.Log("Given the need to react to messages.")
+	{
+		t.Logf("\tTest 0:\tWhen encoding and decoding")
+		{
+			r := msg.Reaction{Emoji: "\U0001F44D", Count: 3}
+			got, err := msg.ParseReaction(r.String())
+			if err != nil || got != r {
+				t.Fatalf("\t%s\tShould round trip reactions : got %+v : %v", failed, got, err)
+			}
+			t.Logf("\t%s\tShould round trip reactions.", succeed)
+
+			if got, err := msg.ParseReaction("\U0001F389"); err != nil || got.Emoji != "\U0001F389" || got.Count != 0 {
+				t.Fatalf("\t%s\tShould accept the emoji alone : got %+v : %v", failed, got, err)
+			}
+			t.Logf("\t%s\tShould accept the emoji alone.", succeed)
+		}
+
+		t.Logf("\tTest 1:\tWhen decoding malformed data")
+		{
+			for _, data := range []string{"", " 3", "+1 x", "+1 -1", strings.Repeat("x", msg.MaxEmojiLength+1)} {
+				if _, err := msg.ParseReaction(data); err != msg.ErrReaction {
+					t.Fatalf("\t%s\tShould refuse the reaction [ %s ] : got %v", failed, data, err)
+				}
+			}
+			t.Logf("\t%s\tShould refuse malformed reactions.", succeed)
+		}
+	}
+}